@@ -3,7 +3,11 @@ package cmd
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+
+	"github.com/zhk-kk/raftpm/global"
+	"github.com/zhk-kk/raftpm/workspace"
 )
 
 var (
@@ -11,6 +15,7 @@ var (
 	ErrUnknownSubcommand       = errors.New("unknown subcommand provided")
 	ErrArgumentMustBeSpecified = errors.New("argument must be specified, but it wasn't")
 	ErrExpectedPath            = errors.New("path was expected, but not received")
+	ErrNoWorkspace             = errors.New("no workspace found")
 )
 
 type Subcommand interface {
@@ -79,3 +84,39 @@ func (n *nested) Name() string { return n.name }
 
 // Dummy function.
 func (*nested) Parse(args []string) error { return nil }
+
+// openWorkspace initializes and loads the workspace located at the provided path.
+// If the path is empty, the workspace raftpm is running from is used.
+func openWorkspace(workspacePath string) (*workspace.Workspace, error) {
+	if workspacePath == "" {
+		workspacePath = global.Global.RunningExecutableDir()
+	}
+
+	w := workspace.NewWorkspace(workspacePath)
+	if err := w.Init(); err != nil {
+		return nil, err
+	}
+
+	if err := w.Load(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// locateWorkspace returns the workspace located at the provided path, without initializing, loading
+// or migrating it. If the path is empty, the workspace raftpm is running from is used.
+func locateWorkspace(workspacePath string) (*workspace.Workspace, error) {
+	if workspacePath == "" {
+		workspacePath = global.Global.RunningExecutableDir()
+	}
+
+	w := workspace.NewWorkspace(workspacePath)
+	if exists, err := w.Exists(); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w at `%s`", ErrNoWorkspace, workspacePath)
+	}
+
+	return w, nil
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zhk-kk/raftpm/workspace"
+	"github.com/zhk-kk/raftpm/workspace/config"
+)
+
+var (
+	ErrExpectedConfigKey = errors.New("config key was expected, but not received")
+	ErrUnknownConfigKey  = errors.New("unknown config key")
+)
+
+// configEntry is a config field, addressed by it's key: `<section>.<field>`.
+type configEntry struct {
+	Key string `json:"key"`
+	config.FieldInfo
+}
+
+// lookupConfig resolves the key into the config file and the field name.
+func lookupConfig(w *workspace.Workspace, key string) (*config.Config, string, error) {
+	section, fieldName, ok := strings.Cut(key, ".")
+	c, found := w.Configs()[section]
+	if !ok || !found {
+		return nil, "", fmt.Errorf("%w: `%s`, expected `<section>.<field>`, where section is one of: %s",
+			ErrUnknownConfigKey, key, strings.Join(sortedKeys(w.Configs()), ", "))
+	}
+	if _, err := c.Field(fieldName); err != nil {
+		return nil, "", fmt.Errorf("%w: `%s`", ErrUnknownConfigKey, key)
+	}
+	return c, fieldName, nil
+}
+
+// configEntries returns all the fields of all the configs, sorted by their keys.
+func configEntries(w *workspace.Workspace) []configEntry {
+	var entries []configEntry
+	for _, section := range sortedKeys(w.Configs()) {
+		for _, info := range w.Configs()[section].Fields() {
+			entries = append(entries, configEntry{section + "." + info.Name, info})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+func printJSON(v any) error {
+	out, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+type configGet struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	jsonOutput    bool
+}
+
+func NewConfigGet() *configGet {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	cg := configGet{fs: fs}
+	fs.StringVar(&cg.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&cg.jsonOutput, "json", false, "print the field in the JSON format")
+	return &cg
+}
+
+func (cg *configGet) Parse(args []string) error {
+	if err := cg.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cg.fs.NArg() == 0 {
+		return fmt.Errorf("config get: %w", ErrExpectedConfigKey)
+	}
+	key := cg.fs.Arg(0)
+
+	w, err := openWorkspace(cg.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	c, fieldName, err := lookupConfig(w, key)
+	if err != nil {
+		return fmt.Errorf("config get: %w", err)
+	}
+	info, err := c.Field(fieldName)
+	if err != nil {
+		return fmt.Errorf("config get: %w", err)
+	}
+
+	if cg.jsonOutput {
+		return printJSON(configEntry{key, info})
+	}
+
+	fmt.Println(config.FormatValue(info.Value))
+
+	return nil
+}
+
+func (*configGet) Name() string { return "get" }
+
+type configSet struct {
+	fs            *flag.FlagSet
+	workspacePath string
+}
+
+func NewConfigSet() *configSet {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	cs := configSet{fs: fs}
+	fs.StringVar(&cs.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	return &cs
+}
+
+func (cs *configSet) Parse(args []string) error {
+	if err := cs.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cs.fs.NArg() < 2 {
+		return fmt.Errorf("config set: %w: expected a key and a value", ErrExpectedConfigKey)
+	}
+	key, value := cs.fs.Arg(0), cs.fs.Arg(1)
+
+	w, err := openWorkspace(cs.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	c, fieldName, err := lookupConfig(w, key)
+	if err != nil {
+		return fmt.Errorf("config set: %w", err)
+	}
+	if err := c.Parse(fieldName, value); err != nil {
+		return fmt.Errorf("config set: %w", err)
+	}
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("config set: %w", err)
+	}
+
+	return nil
+}
+
+func (*configSet) Name() string { return "set" }
+
+type configUnset struct {
+	fs            *flag.FlagSet
+	workspacePath string
+}
+
+func NewConfigUnset() *configUnset {
+	fs := flag.NewFlagSet("unset", flag.ContinueOnError)
+	cu := configUnset{fs: fs}
+	fs.StringVar(&cu.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	return &cu
+}
+
+func (cu *configUnset) Parse(args []string) error {
+	if err := cu.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if cu.fs.NArg() == 0 {
+		return fmt.Errorf("config unset: %w", ErrExpectedConfigKey)
+	}
+	key := cu.fs.Arg(0)
+
+	w, err := openWorkspace(cu.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	c, fieldName, err := lookupConfig(w, key)
+	if err != nil {
+		return fmt.Errorf("config unset: %w", err)
+	}
+	if err := c.Unset(fieldName); err != nil {
+		return fmt.Errorf("config unset: %w", err)
+	}
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("config unset: %w", err)
+	}
+
+	return nil
+}
+
+func (*configUnset) Name() string { return "unset" }
+
+type configList struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	jsonOutput    bool
+}
+
+func NewConfigList() *configList {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	cl := configList{fs: fs}
+	fs.StringVar(&cl.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&cl.jsonOutput, "json", false, "print the fields in the JSON format")
+	return &cl
+}
+
+func (cl *configList) Parse(args []string) error {
+	if err := cl.fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := openWorkspace(cl.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	entries := configEntries(w)
+	if cl.jsonOutput {
+		if entries == nil {
+			entries = []configEntry{}
+		}
+		return printJSON(entries)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "KEY\tTYPE\tVALUE\tSOURCE\tDEFAULT\n")
+	for _, e := range entries {
+		source := "default"
+		if e.ReadOnly {
+			source = "managed"
+		} else if e.IsSet {
+			source = "set"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			e.Key, e.Type, config.FormatValue(e.Value), source, config.FormatValue(e.Default))
+	}
+	tw.Flush()
+
+	return nil
+}
+
+func (*configList) Name() string { return "list" }
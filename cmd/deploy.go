@@ -1,17 +1,32 @@
 package cmd
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"text/tabwriter"
 
 	"github.com/zhk-kk/raftpm/global"
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/utils/files"
 	"github.com/zhk-kk/raftpm/workspace"
+	"github.com/zhk-kk/raftpm/workspace/integration"
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+var (
+	ErrDeployToSelf = errors.New("destination is the workspace being deployed")
 )
 
 type deploy struct {
 	fs              *flag.FlagSet
 	destinationPath string
 	portable        bool
+	dryRun          bool
 }
 
 func NewDeploy() *deploy {
@@ -19,6 +34,7 @@ func NewDeploy() *deploy {
 	d := deploy{fs: fs}
 	fs.StringVar(&d.destinationPath, "dest", "", "path to the destination of deployment")
 	fs.BoolVar(&d.portable, "portable", false, "makes the installation portable (intended to be used on removable drives)")
+	fs.BoolVar(&d.dryRun, "dry-run", false, "print the deployment plan without changing anything")
 	return &d
 }
 
@@ -32,11 +48,17 @@ func (d *deploy) Parse(args []string) error {
 	// [TODO]:
 	//			1. (Done) Load the current workspace.
 	//			2. (DONE) Initialize the workspace in the destination directory.
-	//			3. Clone the store over to the destination workspace.
-	//			4. Self-package to the destination's store.
-	//			5. Run all the detection scripts, caching the result.
-	//			6. Install all the required packages in the destination workspace.
-	//			7. Install raftpm in the destination workspace.
+	//			3. (DONE) Clone the store over to the destination workspace.
+	//			4. (DONE) Self-package to the destination's store.
+	//			5. (DONE) Run all the detection scripts, caching the result.
+	//			6. (DONE) Link all the active packages in the destination workspace.
+	//			7. (DONE) Install raftpm in the destination workspace.
+
+	if same, err := samePath(d.destinationPath, global.Global.RunningExecutableDir()); err != nil {
+		return err
+	} else if same {
+		return fmt.Errorf("deploy: %w", ErrDeployToSelf)
+	}
 
 	// Load the current workspace.
 	curWork := workspace.NewWorkspace(global.Global.RunningExecutableDir())
@@ -44,20 +66,427 @@ func (d *deploy) Parse(args []string) error {
 		return err
 	}
 
-	// Initialize the workspace in the destination directory.
+	// Plan the deployment, so that a dry run shows exactly what the real one does.
+	if d.dryRun {
+		plan, err := planDeploy(curWork, workspace.NewWorkspace(d.destinationPath))
+		if err != nil {
+			return fmt.Errorf("deploy: %w", err)
+		}
+		plan.print(d.portable)
+		return nil
+	}
+
+	// Build the workspace in a staging directory inside the destination, so that a failure leaves it untouched.
 	destWork := workspace.NewWorkspace(d.destinationPath)
-	if err := destWork.Init(); err != nil {
+	entries := append(destWork.ConfigEntries(), destWork.DataEntries()...)
+	staging, err := files.NewStaging(d.destinationPath, append(entries, path.Base(deployExecutablePath(destWork))))
+	if err != nil {
+		return fmt.Errorf("deploy: %w", err)
+	}
+
+	if err := d.build(curWork, destWork, staging); err != nil {
+		if rollbackErr := staging.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("deploy: %w; couldn't remove the staging directory: %w", err, rollbackErr)
+		}
+		return fmt.Errorf("deploy: %w", err)
+	}
+
+	if err := staging.Commit(); err != nil {
+		staging.Rollback()
+		return fmt.Errorf("deploy: couldn't switch the staging directory into place: %w", err)
+	}
+
+	// Run all the detection scripts, caching the result.
+	// It's done in place, so that the scripts see the final workspace path.
+	if err := destWork.Load(); err != nil {
 		return err
 	}
+	detections, err := integration.Detect(destWork, false)
+	if err != nil {
+		return fmt.Errorf("deploy: %w", err)
+	}
+	printDetections(detections)
 
-	err := destWork.Editor().
+	return nil
+}
+
+func (*deploy) Name() string { return "deploy" }
+
+// build deploys the current workspace to the staging directory of the destination one.
+func (d *deploy) build(curWork *workspace.Workspace, destWork *workspace.Workspace, staging *files.Staging) error {
+	// The plan is made against the destination, since the staged data may be carried over only by the commit.
+	plan, err := planDeploy(curWork, destWork)
+	if err != nil {
+		return err
+	}
+
+	// The configs are needed to load the staged workspace, so they're copied where they can't be linked.
+	for _, entry := range destWork.ConfigEntries() {
+		if err := staging.Copy(entry); err != nil {
+			return fmt.Errorf("couldn't stage `%s`: %w", entry, err)
+		}
+	}
+	for _, entry := range destWork.DataEntries() {
+		// The links are recreated for the active packages below, so that the stale ones don't survive.
+		if entry == path.Base(destWork.Links().Path()) {
+			continue
+		}
+		if err := staging.Link(entry); err != nil {
+			return fmt.Errorf("couldn't stage `%s`: %w", entry, err)
+		}
+	}
+	stageWork := workspace.NewWorkspace(staging.Path)
+
+	// Initialize the workspace in the staging directory.
+	if err := stageWork.Init(); err != nil {
+		return err
+	}
+	if err := stageWork.Load(); err != nil {
+		return err
+	}
+
+	err = stageWork.Editor().
 		Portable(d.portable).
 		ApplyChanges()
 	if err != nil {
 		return err
 	}
 
+	// Clone the store over to the destination workspace, only writing what changed.
+	// The unchanged files are carried over from the destination store, in case they couldn't be linked.
+	storeEntry, err := filepath.Rel(staging.Path, stageWork.Store().Path())
+	if err != nil {
+		return err
+	}
+	plan.clone.Dst = stageWork.Store().Path()
+	for _, op := range plan.clone.Ops {
+		switch op.Action {
+		case files.SyncSkip:
+			staging.Carry(path.Join(filepath.ToSlash(storeEntry), op.Path))
+		case files.SyncRemove:
+			staging.Drop(path.Join(filepath.ToSlash(storeEntry), op.Path))
+		}
+	}
+	if err := plan.clone.Apply(); err != nil {
+		return fmt.Errorf("couldn't clone the store: %w", err)
+	}
+	fmt.Printf("store: %d files copied (%d bytes), %d unchanged, %d removed\n",
+		plan.clone.Count(files.SyncCopy), plan.clone.BytesToWrite(),
+		plan.clone.Count(files.SyncSkip), plan.clone.Count(files.SyncRemove))
+
+	// The objects aren't cloned, so the identical files have to be linked together again.
+	deduplicated, err := stageWork.Store().Deduplicate()
+	if err != nil {
+		return fmt.Errorf("couldn't deduplicate the store: %w", err)
+	}
+	if deduplicated != 0 {
+		fmt.Printf("store: %d files deduplicated\n", deduplicated)
+	}
+
+	// Self-package to the destination's store.
+	if err := installSelf(stageWork, staging, plan.self); err != nil {
+		return fmt.Errorf("couldn't install raftpm into the store: %w", err)
+	}
+
+	// Link all the active packages in the destination workspace.
+	for _, p := range plan.activated {
+		staged, err := lookupInstalled(stageWork.Store(), p.Name(), p.CommonInfo.PkgVersion.String())
+		if err != nil {
+			return err
+		}
+		if err := stageWork.Activate(staged); err != nil {
+			return fmt.Errorf("couldn't link %s: %w", describePackage(p), err)
+		}
+	}
+
+	// Install raftpm in the destination workspace, so that it could be run from there.
+	info, err := os.Stat(plan.executable)
+	if err != nil {
+		return err
+	}
+	stagedExecutable := path.Join(staging.Path, path.Base(plan.executableDst))
+	if err := files.CopyFile(plan.executable, stagedExecutable, info.Mode(), info.ModTime()); err != nil {
+		return fmt.Errorf("couldn't copy the raftpm executable: %w", err)
+	}
+
 	return nil
 }
 
-func (*deploy) Name() string { return "deploy" }
+// selfPackageFileName is the name of the self-package file, which is built in the staging directory.
+// It's removed along with the staging directory.
+const selfPackageFileName = ".raftpm-self.raftpm"
+
+// installSelf packages the running raftpm, and installs it into the staged workspace.
+// A version, which is already installed, is only activated.
+func installSelf(stageWork *workspace.Workspace, staging *files.Staging, self deploySelf) error {
+	if self.pinnedTo != "" {
+		return nil
+	}
+	if self.installed {
+		p, err := lookupInstalled(stageWork.Store(), self.name, self.version)
+		if err != nil {
+			return err
+		}
+		return stageWork.Activate(p)
+	}
+
+	file, err := os.Create(path.Join(staging.Path, selfPackageFileName))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := pkg.GenerateSelfPackage(file); err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = stageWork.Install(file, stat.Size(), store.InstallReasonExplicit)
+	return err
+}
+
+// deployExecutablePath returns the path the raftpm executable is copied to in the destination workspace.
+func deployExecutablePath(destWork *workspace.Workspace) string {
+	return path.Join(destWork.Path(), path.Base(filepath.ToSlash(global.Global.RunningExecutablePath())))
+}
+
+// samePath reports whether both paths refer to the same location.
+func samePath(a string, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return absA == absB, nil
+}
+
+// deployPlan describes the changes a deployment makes to the destination workspace.
+type deployPlan struct {
+	// mkdirs lists the workspace directories missing from the destination.
+	mkdirs []string
+	clone  *files.SyncPlan
+	// added and removed list the packages the clone adds to, or removes from the destination store.
+	added   []store.Package
+	removed []store.Package
+	// self is the package of the running raftpm, which is installed into the destination store.
+	self deploySelf
+	// activated lists the active packages of the cloned store, which are linked in the destination.
+	activated []store.Package
+	// executable is the running raftpm executable, which is copied to executableDst.
+	executable     string
+	executableDst  string
+	executableSize int64
+	// detections lists the detection scripts to run, along with the cached results to reuse.
+	detections []integration.Detection
+}
+
+// deploySelf describes how the running raftpm is installed into the destination store.
+type deploySelf struct {
+	name    string
+	version string
+	// size is the number of bytes the packaged files take.
+	size int64
+	// installed is set, if the version is already in the cloned store, so it's only activated.
+	installed bool
+	// pinnedTo is the active version of raftpm, if the destination pins it to another one.
+	// The running raftpm isn't installed then.
+	pinnedTo string
+}
+
+// planDeploy plans the deployment of the current workspace, without changing anything.
+// It covers the steps deploy performs: creating the workspace, cloning the store, installing raftpm into it,
+// linking the active packages, copying the raftpm executable and running the detection scripts.
+func planDeploy(curWork *workspace.Workspace, destWork *workspace.Workspace) (*deployPlan, error) {
+	plan := deployPlan{}
+
+	for _, dir := range destWork.Dirs() {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			plan.mkdirs = append(plan.mkdirs, dir)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	clone, err := curWork.Store().PlanClone(destWork.Store())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't plan the store clone: %w", err)
+	}
+	plan.clone = clone
+
+	curPackages, err := curWork.Store().Packages()
+	if err != nil {
+		return nil, err
+	}
+	destPackages, err := destWork.Store().Packages()
+	if err != nil {
+		return nil, err
+	}
+	plan.added = missingPackages(curPackages, destPackages)
+	plan.removed = missingPackages(destPackages, curPackages)
+
+	plan.self, err = planSelf(destWork, curPackages)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't plan the self-package: %w", err)
+	}
+
+	// After the clone the destination store has the same active versions as the current one.
+	// The running raftpm is activated by it's own step.
+	for _, p := range curPackages {
+		if _, ok := p.Manifest.(manifest.BinaryPkg); !ok || !p.Active {
+			continue
+		}
+		if p.Name() == plan.self.name && plan.self.pinnedTo == "" {
+			continue
+		}
+		plan.activated = append(plan.activated, p)
+	}
+
+	plan.executable = global.Global.RunningExecutablePath()
+	plan.executableDst = deployExecutablePath(destWork)
+	info, err := os.Stat(plan.executable)
+	if err != nil {
+		return nil, err
+	}
+	plan.executableSize = info.Size()
+
+	// After the clone the destination store has the same packages as the current one.
+	plan.detections, err = integration.PlanDetect(destWork.Cache(), curPackages, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// planSelf plans the installation of the running raftpm into the cloned store.
+func planSelf(destWork *workspace.Workspace, curPackages []store.Package) (deploySelf, error) {
+	selfManifest, selfInfo, err := pkg.SelfPackageManifest()
+	if err != nil {
+		return deploySelf{}, err
+	}
+	self := deploySelf{name: selfManifest.Name, version: selfInfo.PkgVersion.String()}
+
+	cpNames, cpFiles, err := pkg.SelfPackageFiles()
+	if err != nil {
+		return self, err
+	}
+	for _, name := range cpNames {
+		info, err := os.Stat(cpFiles[name])
+		if err != nil {
+			return self, err
+		}
+		self.size += info.Size()
+	}
+
+	// The destination keeps it's own configs, so it's pins apply to the cloned store.
+	if err := destWork.Configs()[workspace.ConfigSectionStore].Read(); err != nil && !os.IsNotExist(err) {
+		return self, err
+	}
+
+	for _, p := range curPackages {
+		if _, ok := p.Manifest.(manifest.BinaryPkg); !ok || p.Name() != self.name {
+			continue
+		}
+		version := p.CommonInfo.PkgVersion.String()
+		if version == self.version {
+			self.installed = true
+		} else if p.Active && destWork.Store().IsPinned(self.name) {
+			self.pinnedTo = version
+		}
+	}
+	return self, nil
+}
+
+// bytesToWrite returns the number of bytes the deployment writes.
+func (p *deployPlan) bytesToWrite() int64 {
+	size := p.clone.BytesToWrite() + p.executableSize
+	if p.self.pinnedTo == "" && !p.self.installed {
+		size += p.self.size
+	}
+	return size
+}
+
+// print prints the plan in a human-readable format.
+func (p *deployPlan) print(portable bool) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Printf("directories to create:\n")
+	for _, dir := range p.mkdirs {
+		fmt.Printf("  %s\n", dir)
+	}
+	fmt.Printf("portable: %t\n", portable)
+
+	fmt.Printf("\nstore (%s -> %s):\n", p.clone.Src, p.clone.Dst)
+	for _, op := range p.clone.Ops {
+		switch op.Action {
+		case files.SyncMkdir:
+			fmt.Fprintf(tw, "  mkdir\t\t%s\n", op.Path)
+		case files.SyncCopy:
+			fmt.Fprintf(tw, "  copy\t%d\t%s\n", op.Size, op.Path)
+		case files.SyncSkip:
+			fmt.Fprintf(tw, "  skip\t%d\t%s\n", op.Size, op.Path)
+		case files.SyncRemove:
+			fmt.Fprintf(tw, "  remove\t\t%s\n", op.Path)
+		}
+	}
+	tw.Flush()
+
+	fmt.Printf("\npackages added by the store clone:\n")
+	for _, added := range p.added {
+		fmt.Printf("  %s\n", describePackage(added))
+	}
+	fmt.Printf("packages removed by the store clone:\n")
+	for _, removed := range p.removed {
+		fmt.Printf("  %s\n", describePackage(removed))
+	}
+
+	fmt.Printf("\nself-package:\n")
+	switch {
+	case p.self.pinnedTo != "":
+		fmt.Printf("  %s %s: skipped, pinned to %s\n", p.self.name, p.self.version, p.self.pinnedTo)
+	case p.self.installed:
+		fmt.Printf("  %s %s: already installed, activate\n", p.self.name, p.self.version)
+	default:
+		fmt.Printf("  %s %s: install (%d bytes)\n", p.self.name, p.self.version, p.self.size)
+	}
+	fmt.Printf("packages to link:\n")
+	for _, activated := range p.activated {
+		fmt.Printf("  %s\n", describePackage(activated))
+	}
+
+	fmt.Printf("\nexecutable:\n  %s -> %s (%d bytes)\n", p.executable, p.executableDst, p.executableSize)
+
+	fmt.Printf("\ndetection scripts:\n")
+	for _, d := range p.detections {
+		if d.Cached {
+			fmt.Fprintf(tw, "  %s\tcached (detected: %t)\n", d.TargetName, d.Detected)
+		} else {
+			fmt.Fprintf(tw, "  %s\trun\n", d.TargetName)
+		}
+	}
+	tw.Flush()
+
+	fmt.Printf("\ntotal: %d bytes to write\n", p.bytesToWrite())
+}
+
+// missingPackages returns the packages, which aren't present among the other ones.
+func missingPackages(packages []store.Package, others []store.Package) []store.Package {
+	present := make(map[string]bool)
+	for _, p := range others {
+		present[describePackage(p)] = true
+	}
+
+	var missing []store.Package
+	for _, p := range packages {
+		if !present[describePackage(p)] {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/zhk-kk/raftpm/workspace/integration"
+)
+
+type detect struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	force         bool
+}
+
+func NewDetect() *detect {
+	fs := flag.NewFlagSet("detect", flag.ContinueOnError)
+	d := detect{fs: fs}
+	fs.StringVar(&d.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&d.force, "force", false, "rerun the detection scripts, ignoring the cached results")
+	return &d
+}
+
+func (d *detect) Parse(args []string) error {
+	if err := d.fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := openWorkspace(d.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	detections, err := integration.Detect(w, d.force)
+	if err != nil {
+		return fmt.Errorf("detect: %w", err)
+	}
+
+	printDetections(detections)
+
+	return nil
+}
+
+func (*detect) Name() string { return "detect" }
+
+// printDetections prints the detection results as a table.
+func printDetections(detections []integration.Detection) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TARGET\tTYPE\tDETECTED\tDETAILS\n")
+	for _, d := range detections {
+		detected := fmt.Sprint(d.Detected)
+		details := d.Details
+		if d.Err != nil {
+			detected = "error"
+			details = d.Err.Error()
+		} else if d.Cached {
+			detected += " (cached)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.TargetName, d.TargetType, detected, details)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUnsupportedShell = errors.New("unsupported shell")
+)
+
+// envShells lists the shells, for which the snippets could be printed.
+var envShells = []string{"bash", "zsh", "fish", "sh"}
+
+// posixEnvSnippet is the activation snippet for bash, zsh and POSIX sh.
+// The arguments are the quoted links directory and workspace paths.
+const posixEnvSnippet = `if [ -n "${_RAFTPM_OLD_PATH+x}" ]; then deactivate; fi
+_RAFTPM_OLD_PATH="$PATH"
+PATH=%s:"$PATH"
+RAFTPM_WORKSPACE=%s
+export PATH RAFTPM_WORKSPACE
+deactivate() {
+    PATH="$_RAFTPM_OLD_PATH"
+    export PATH
+    unset _RAFTPM_OLD_PATH RAFTPM_WORKSPACE
+    unset -f deactivate
+    hash -r 2>/dev/null
+}
+hash -r 2>/dev/null
+`
+
+// fishEnvSnippet is the activation snippet for fish.
+// The arguments are the quoted links directory and workspace paths.
+const fishEnvSnippet = `if set -q _RAFTPM_OLD_PATH; deactivate; end
+set -gx _RAFTPM_OLD_PATH $PATH
+set -gx PATH %s $PATH
+set -gx RAFTPM_WORKSPACE %s
+function deactivate
+    set -gx PATH $_RAFTPM_OLD_PATH
+    set -e _RAFTPM_OLD_PATH
+    set -e RAFTPM_WORKSPACE
+    functions -e deactivate
+end
+`
+
+type env struct {
+	fs            *flag.FlagSet
+	name          string
+	workspacePath string
+	shell         string
+}
+
+// NewEnv creates the `env` subcommand, printing the shell snippet for using the workspace:
+// `eval "$(raftpm env)"`.
+func NewEnv() *env { return newEnv("env") }
+
+// NewActivate creates the `activate` subcommand, which is an alias of `env`.
+func NewActivate() *env { return newEnv("activate") }
+
+func newEnv(name string) *env {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	e := env{fs: fs, name: name}
+	fs.StringVar(&e.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.StringVar(&e.shell, "shell", "", "shell to print the snippet for: "+strings.Join(envShells, ", ")+" (defaults to $SHELL)")
+	return &e
+}
+
+func (e *env) Parse(args []string) error {
+	if err := e.fs.Parse(args); err != nil {
+		return err
+	}
+
+	shell := e.shell
+	if shell == "" {
+		shell = defaultShell()
+	}
+
+	// Printing the snippet only needs the paths, so the workspace isn't loaded, nor migrated.
+	w, err := locateWorkspace(e.workspacePath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.name, err)
+	}
+
+	linksPath, err := filepath.Abs(w.Links().Path())
+	if err != nil {
+		return err
+	}
+	workspacePath, err := filepath.Abs(w.Path())
+	if err != nil {
+		return err
+	}
+
+	switch shell {
+	case "bash", "zsh", "sh":
+		fmt.Printf(posixEnvSnippet, posixQuote(linksPath), posixQuote(workspacePath))
+	case "fish":
+		fmt.Printf(fishEnvSnippet, fishQuote(linksPath), fishQuote(workspacePath))
+	default:
+		return fmt.Errorf("%s: %w: `%s`", e.name, ErrUnsupportedShell, shell)
+	}
+
+	return nil
+}
+
+func (e *env) Name() string { return e.name }
+
+// defaultShell returns the user's shell, if it's supported, otherwise `sh`.
+func defaultShell() string {
+	shell := filepath.Base(os.Getenv("SHELL"))
+	for _, supported := range envShells {
+		if shell == supported {
+			return shell
+		}
+	}
+	return "sh"
+}
+
+// posixQuote quotes the string for POSIX shells.
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes the string for fish, which allows escaping quotes inside of single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
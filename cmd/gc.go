@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/workspace"
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+type gc struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	dryRun        bool
+}
+
+func NewGC() *gc {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	g := gc{fs: fs}
+	fs.StringVar(&g.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&g.dryRun, "dry-run", false, "print what would be removed without changing anything")
+	return &g
+}
+
+func (g *gc) Parse(args []string) error {
+	if err := g.fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := openWorkspace(g.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	plan, err := planGC(w)
+	if err != nil {
+		return fmt.Errorf("gc: %w", err)
+	}
+
+	plan.print()
+	if g.dryRun {
+		fmt.Printf("\ntotal: %d bytes would be freed\n", plan.size)
+		return nil
+	}
+
+	if err := plan.apply(w); err != nil {
+		return fmt.Errorf("gc: %w", err)
+	}
+	fmt.Printf("\ntotal: %d bytes freed\n", plan.size)
+
+	return nil
+}
+
+func (*gc) Name() string { return "gc" }
+
+// gcPlan lists everything the garbage collection removes.
+type gcPlan struct {
+	// packages are the versions, which are neither active, nor needed as a dependency.
+	packages []store.Package
+	// objects are the store objects, which no installed package uses.
+	objects []string
+	// cachedPackages and cachedTargets are the cache entries of the packages, which aren't installed.
+	cachedPackages []string
+	cachedTargets  []string
+
+	// size is the number of bytes freed.
+	size int64
+}
+
+// planGC collects the garbage of the workspace, without removing anything.
+func planGC(w *workspace.Workspace) (*gcPlan, error) {
+	var plan gcPlan
+	var err error
+
+	plan.packages, err = w.Store().Unused()
+	if err != nil {
+		return nil, err
+	}
+	plan.objects, err = w.Store().Orphans()
+	if err != nil {
+		return nil, err
+	}
+	plan.size, err = w.Store().Reclaimable(plan.packages, plan.objects)
+	if err != nil {
+		return nil, err
+	}
+
+	// The original package files of the removed versions are dropped from the cache as well.
+	for _, p := range plan.packages {
+		archivePath, ok, err := w.Archive(p)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		plan.size += info.Size()
+	}
+
+	// The cached data is kept for every package, which remains installed in some version.
+	packages, err := w.Store().Packages()
+	if err != nil {
+		return nil, err
+	}
+	installedApps := make(map[string]bool)
+	installedTargets := make(map[string]bool)
+	for _, p := range missingPackages(packages, plan.packages) {
+		switch pkgManifest := p.Manifest.(type) {
+		case manifest.BinaryPkg:
+			installedApps[pkgManifest.Name] = true
+		case manifest.IntegrationScriptsPkg:
+			installedTargets[pkgManifest.TargetName] = true
+		}
+	}
+
+	cachedPackages, err := w.Cache().PackageNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range cachedPackages {
+		if installedApps[name] {
+			continue
+		}
+		size, err := w.Cache().PackageSize(name)
+		if err != nil {
+			return nil, err
+		}
+		plan.cachedPackages = append(plan.cachedPackages, name)
+		plan.size += size
+	}
+
+	cachedTargets, err := w.Cache().TargetNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, targetName := range cachedTargets {
+		if installedTargets[targetName] {
+			continue
+		}
+		size, err := w.Cache().TargetSize(targetName)
+		if err != nil {
+			return nil, err
+		}
+		plan.cachedTargets = append(plan.cachedTargets, targetName)
+		plan.size += size
+	}
+
+	return &plan, nil
+}
+
+// print prints the plan in a human-readable format.
+func (p *gcPlan) print() {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Printf("unused packages:\n")
+	for _, pkg := range p.packages {
+		fmt.Printf("  %s\n", describePackage(pkg))
+	}
+	fmt.Printf("orphaned objects: %d\n", len(p.objects))
+
+	fmt.Printf("\nstale cache entries:\n")
+	for _, name := range p.cachedPackages {
+		fmt.Fprintf(tw, "  package\t%s\n", name)
+	}
+	for _, targetName := range p.cachedTargets {
+		fmt.Fprintf(tw, "  target\t%s\n", targetName)
+	}
+	tw.Flush()
+}
+
+// apply removes everything listed in the plan.
+func (p *gcPlan) apply(w *workspace.Workspace) error {
+	for _, pkg := range p.packages {
+		if err := w.Uninstall(pkg); err != nil {
+			return fmt.Errorf("couldn't remove %s: %w", describePackage(pkg), err)
+		}
+	}
+
+	if err := w.Store().RemoveOrphans(p.objects); err != nil {
+		return fmt.Errorf("couldn't remove the orphaned objects: %w", err)
+	}
+
+	for _, name := range p.cachedPackages {
+		if err := w.Cache().DropPackage(name); err != nil {
+			return err
+		}
+	}
+	for _, targetName := range p.cachedTargets {
+		if err := w.Cache().DropTarget(targetName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
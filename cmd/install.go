@@ -0,0 +1,381 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/pkg/resolver"
+	"github.com/zhk-kk/raftpm/pkg/signing"
+	"github.com/zhk-kk/raftpm/workspace"
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+var (
+	ErrPackageFileChanged = errors.New("package file changed since it was scanned")
+)
+
+// packageFileExt is the extension of the package files, which are looked up in the dependencies directory.
+const packageFileExt = ".raftpm"
+
+type install struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	depsDir       string
+	ignoreArch    bool
+}
+
+func NewInstall() *install {
+	fs := flag.NewFlagSet("install", flag.ContinueOnError)
+	i := install{fs: fs}
+	fs.StringVar(&i.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.StringVar(&i.depsDir, "deps", "", "directory with the package files to install the missing dependencies from")
+	fs.BoolVar(&i.ignoreArch, "ignore-arch", false, "install packages built for another host architecture")
+	return &i
+}
+
+func (i *install) Parse(args []string) error {
+	if err := i.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if i.fs.NArg() == 0 {
+		return fmt.Errorf("install: %w: package file", ErrExpectedPath)
+	}
+
+	w, err := openWorkspace(i.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	order, err := planInstall(w, i.fs.Args(), i.depsDir, i.ignoreArch)
+	if err != nil {
+		return fmt.Errorf("install: %w", err)
+	}
+	defer closePlanned(order)
+
+	for _, planned := range order {
+		if err := installFile(w, planned); err != nil {
+			return fmt.Errorf("install: `%s`: %w", planned.path, err)
+		}
+	}
+
+	return nil
+}
+
+func (*install) Name() string { return "install" }
+
+// plannedInstall is a package file, scheduled for installation.
+// The file stays open from the signature check until it's installed, so it can't be swapped in between.
+type plannedInstall struct {
+	path string
+	file *os.File
+	size int64
+	// reason is one of store.InstallReasonXxx.
+	reason string
+}
+
+// planInstall resolves the dependencies of the provided package files against each other, the package files
+// in depsDir and the installed packages, returning the files in the order they should be installed in.
+// The provided files are installed explicitly, even if the other ones require them. The files from depsDir
+// are only installed, if they're needed to satisfy a dependency.
+// Unless ignoreArch is set, packages built for another host are refused.
+// The returned files are open, and should be closed with closePlanned.
+func planInstall(w *workspace.Workspace, pkgPaths []string, depsDir string, ignoreArch bool) (_ []plannedInstall, err error) {
+	var order []plannedInstall
+	var requested []resolver.Candidate
+	candidatePaths := make(map[string]string)
+	opened := make(map[string]plannedInstall)
+	defer func() {
+		if err != nil {
+			for _, planned := range opened {
+				planned.file.Close()
+			}
+		}
+	}()
+
+	for _, pkgPath := range pkgPaths {
+		if _, ok := opened[pkgPath]; ok {
+			continue
+		}
+
+		file, size, compiled, err := openPackageFile(w, pkgPath)
+		if err != nil {
+			return nil, fmt.Errorf("`%s`: %w", pkgPath, err)
+		}
+		planned := plannedInstall{pkgPath, file, size, store.InstallReasonExplicit}
+		opened[pkgPath] = planned
+		pkgManifest, pkgCommonInfo := compiled.Manifest(), compiled.CommonInfo()
+
+		if err := pkg.CheckRaftpmVersion(pkgCommonInfo); err != nil {
+			return nil, fmt.Errorf("`%s`: %w", pkgPath, err)
+		}
+
+		binPkg, ok := pkgManifest.(manifest.BinaryPkg)
+		if !ok {
+			// Integration scripts packages have no dependencies.
+			order = append(order, planned)
+			continue
+		}
+
+		if !ignoreArch {
+			if err := pkg.CheckHostArch(binPkg); err != nil {
+				return nil, fmt.Errorf("`%s`: %w (use `-ignore-arch` to install anyway)", pkgPath, err)
+			}
+		}
+
+		if err := w.Store().CheckNotPinned(binPkg.Name, pkgCommonInfo.PkgVersion); err != nil {
+			return nil, fmt.Errorf("`%s`: %w (use `unpin %s` to allow upgrades)", pkgPath, err, binPkg.Name)
+		}
+
+		c := resolver.Candidate{
+			Name:         binPkg.Name,
+			Version:      pkgCommonInfo.PkgVersion,
+			Dependencies: binPkg.Dependencies,
+		}
+		requested = append(requested, c)
+		candidatePaths[c.String()] = pkgPath
+	}
+
+	// The package files in depsDir and the installed packages may satisfy the dependencies as well.
+	available, err := scanPackageFiles(depsDir, ignoreArch, candidatePaths)
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := w.Store().Packages()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range installed {
+		if binPkg, ok := p.Manifest.(manifest.BinaryPkg); ok {
+			available = append(available, resolver.Candidate{
+				Name:         binPkg.Name,
+				Version:      p.CommonInfo.PkgVersion,
+				Dependencies: binPkg.Dependencies,
+				Installed:    true,
+			})
+		}
+	}
+
+	resolved, err := resolver.Resolve(requested, available)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range resolved {
+		pkgPath := candidatePaths[c.String()]
+		planned, ok := opened[pkgPath]
+		if !ok {
+			planned, err = openDependency(w, pkgPath, c)
+			if err != nil {
+				return nil, fmt.Errorf("`%s`: %w", pkgPath, err)
+			}
+			opened[pkgPath] = planned
+		}
+		order = append(order, planned)
+	}
+
+	return order, nil
+}
+
+// scanPackageFiles reads the manifests of the binary package files in the directory, which could be installed
+// on this host, and records their paths in candidatePaths. Packages already there are skipped.
+// The files aren't verified, that's left for the ones, which get installed.
+func scanPackageFiles(dir string, ignoreArch bool, candidatePaths map[string]string) ([]resolver.Candidate, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []resolver.Candidate
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != packageFileExt {
+			continue
+		}
+		pkgPath := filepath.Join(dir, e.Name())
+
+		pkgManifest, pkgCommonInfo, err := readPackageManifest(pkgPath)
+		if err != nil {
+			return nil, fmt.Errorf("`%s`: %w", pkgPath, err)
+		}
+		binPkg, ok := pkgManifest.(manifest.BinaryPkg)
+		if !ok || pkg.CheckRaftpmVersion(pkgCommonInfo) != nil {
+			continue
+		}
+		if !ignoreArch && pkg.CheckHostArch(binPkg) != nil {
+			continue
+		}
+
+		c := resolver.Candidate{
+			Name:         binPkg.Name,
+			Version:      pkgCommonInfo.PkgVersion,
+			Dependencies: binPkg.Dependencies,
+		}
+		if _, ok := candidatePaths[c.String()]; ok {
+			continue
+		}
+		candidatePaths[c.String()] = pkgPath
+		candidates = append(candidates, c)
+	}
+	return candidates, nil
+}
+
+// readPackageManifest reads the manifest of the compiled package file.
+func readPackageManifest(pkgPath string) (interface{}, manifest.PkgCommonInfo, error) {
+	file, err := os.Open(pkgPath)
+	if err != nil {
+		return nil, manifest.PkgCommonInfo{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, manifest.PkgCommonInfo{}, err
+	}
+
+	compiled, err := pkg.OpenPackage(file, stat.Size())
+	if err != nil {
+		return nil, manifest.PkgCommonInfo{}, err
+	}
+	return compiled.Manifest(), compiled.CommonInfo(), nil
+}
+
+// openDependency opens the package file picked to satisfy a dependency, verifying it like the provided ones.
+// Since the file was only scanned before, it's checked to still hold the resolved package.
+func openDependency(w *workspace.Workspace, pkgPath string, c resolver.Candidate) (plannedInstall, error) {
+	file, size, compiled, err := openPackageFile(w, pkgPath)
+	if err != nil {
+		return plannedInstall{}, err
+	}
+
+	binPkg, ok := compiled.Manifest().(manifest.BinaryPkg)
+	if !ok || binPkg.Name != c.Name || !compiled.CommonInfo().PkgVersion.EQ(c.Version) {
+		file.Close()
+		return plannedInstall{}, fmt.Errorf("%w: expected %s", ErrPackageFileChanged, c)
+	}
+
+	if err := w.Store().CheckNotPinned(c.Name, c.Version); err != nil {
+		file.Close()
+		return plannedInstall{}, fmt.Errorf("%w (use `unpin %s` to allow upgrades)", err, c.Name)
+	}
+
+	return plannedInstall{pkgPath, file, size, store.InstallReasonDependency}, nil
+}
+
+// openPackageFile opens the compiled package file and verifies it's signature and hash list according
+// to the workspace policy. The returned file is left open for the installation.
+func openPackageFile(w *workspace.Workspace, pkgPath string) (*os.File, int64, *pkg.Package, error) {
+	file, err := os.Open(pkgPath)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+
+	compiled, err := pkg.OpenPackage(file, stat.Size())
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+
+	if err := checkSignature(w, compiled, pkgPath); err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+	if err := checkHashList(w, compiled, pkgPath); err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+
+	return file, stat.Size(), compiled, nil
+}
+
+// closePlanned closes the package files of the plan.
+func closePlanned(order []plannedInstall) {
+	for _, planned := range order {
+		planned.file.Close()
+	}
+}
+
+// checkSignature verifies the signature of the package, applying the signature policy of the workspace.
+// A detached signature is looked up at `<package>.sig`, otherwise the embedded one is used.
+func checkSignature(w *workspace.Workspace, compiled *pkg.Package, pkgPath string) error {
+	var detached *signing.Signature
+	if rawSig, err := os.ReadFile(pkgPath + ".sig"); err == nil {
+		sig, err := signing.ParseSignature(rawSig)
+		if err != nil {
+			return err
+		}
+		detached = &sig
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Unsigned packages are accepted silently only under `allow-unsigned`. A signature by an
+	// untrusted key is never silent: it's rejected, or at least warned about.
+	_, err := signing.Verify(compiled, detached, w.Keyring())
+	policy := w.SignaturePolicy()
+	if errors.Is(err, signing.ErrUnsigned) && policy == workspace.SignaturePolicyAllowUnsigned {
+		return nil
+	}
+	if (errors.Is(err, signing.ErrUnsigned) || errors.Is(err, signing.ErrUntrustedKey)) && policy != workspace.SignaturePolicyReject {
+		fmt.Printf("warning: `%s`: %s\n", pkgPath, err)
+		return nil
+	}
+
+	return err
+}
+
+// checkHashList applies the signature policy of the workspace to packages without a hash list,
+// since their contents can't be verified either.
+func checkHashList(w *workspace.Workspace, compiled *pkg.Package, pkgPath string) error {
+	_, err := compiled.Hashes()
+	if !errors.Is(err, pkg.ErrNoHashList) {
+		return err
+	}
+
+	switch w.SignaturePolicy() {
+	case workspace.SignaturePolicyAllowUnsigned:
+		return nil
+	case workspace.SignaturePolicyWarn:
+		fmt.Printf("warning: `%s`: %s, the installed files can't be verified\n", pkgPath, err)
+		return nil
+	}
+	return err
+}
+
+// installFile installs the planned package file into the workspace.
+func installFile(w *workspace.Workspace, planned plannedInstall) error {
+	p, err := w.Install(planned.file, planned.size, planned.reason)
+	if err != nil {
+		return err
+	}
+
+	if planned.reason == store.InstallReasonDependency {
+		fmt.Printf("installed %s as a dependency\n", describePackage(p))
+	} else {
+		fmt.Printf("installed %s\n", describePackage(p))
+	}
+
+	return nil
+}
+
+// describePackage returns a human-readable description of the installed package.
+func describePackage(p store.Package) string {
+	if _, ok := p.Manifest.(manifest.IntegrationScriptsPkg); ok {
+		return fmt.Sprintf("integration scripts for %s %s", p.Name(), p.CommonInfo.PkgVersion)
+	}
+	return fmt.Sprintf("%s %s", p.Name(), p.CommonInfo.PkgVersion)
+}
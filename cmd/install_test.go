@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/paths"
+	"github.com/zhk-kk/raftpm/workspace"
+)
+
+// compilePackage compiles a binary package for the host into dir, returning the path of the package file.
+func compilePackage(t *testing.T, dir string, name string, version string, dependencies map[string]string) string {
+	t.Helper()
+	cpu, hostOs, err := pkg.HostArch()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	rawManifest, err := json.Marshal(map[string]interface{}{
+		"raftpmVersion": "0.0.0",
+		"name":          name,
+		"version":       version,
+		"type":          "binPkg",
+		"arch":          map[string][]string{pkg.ArchKeyCpu: {cpu}, pkg.ArchKeyOs: {hostOs}},
+		"about":         map[string]string{},
+		"binRegistry":   map[string]string{"exe": "local:" + name},
+		"binShellExe":   map[string]string{name: "exe"},
+		"dependencies":  dependencies,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templatePath := filepath.Join(t.TempDir(), name)
+	for filePath, contents := range map[string][]byte{
+		filepath.Join(templatePath, filepath.FromSlash(paths.ManifestFile)): rawManifest,
+		filepath.Join(templatePath, paths.CopyDataDir, name):                []byte(name + " " + version),
+	} {
+		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, contents, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pkgPath := filepath.Join(dir, name+"-"+version+packageFileExt)
+	out, err := os.Create(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := pkg.CompileTemplate(templatePath, out); err != nil {
+		t.Fatal(err)
+	}
+	return pkgPath
+}
+
+func TestInstallReasons(t *testing.T) {
+	depsDir := t.TempDir()
+	compilePackage(t, depsDir, "lib", "1.0.0", nil)
+	compilePackage(t, depsDir, "lib", "1.5.0", nil)
+	compilePackage(t, depsDir, "lib", "2.0.0", nil)
+	compilePackage(t, depsDir, "other", "1.0.0", nil)
+	app := compilePackage(t, t.TempDir(), "app", "1.0.0", map[string]string{"lib": ">=1.0.0 <2.0.0"})
+
+	tests := []struct {
+		name     string
+		pkgPaths []string
+		want     []string
+	}{
+		{
+			name:     "missing dependency is installed from the directory",
+			pkgPaths: []string{app},
+			want:     []string{"app 1.0.0 explicit", "lib 1.5.0 dependency"},
+		},
+		{
+			name:     "named dependency is explicit",
+			pkgPaths: []string{app, filepath.Join(depsDir, "lib-1.0.0"+packageFileExt)},
+			want:     []string{"app 1.0.0 explicit", "lib 1.0.0 explicit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := workspace.NewWorkspace(t.TempDir())
+			if err := w.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Configs()[workspace.ConfigSectionWorkspace].Parse("signaturePolicy", workspace.SignaturePolicyAllowUnsigned); err != nil {
+				t.Fatal(err)
+			}
+
+			order, err := planInstall(w, tt.pkgPaths, depsDir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer closePlanned(order)
+			for _, planned := range order {
+				if err := installFile(w, planned); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			for _, e := range w.Store().Index() {
+				got = append(got, e.Name+" "+e.Version+" "+e.Reason)
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
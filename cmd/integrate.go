@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zhk-kk/raftpm/workspace/integration"
+)
+
+type integrate struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	forceDetect   bool
+}
+
+func NewIntegrate() *integrate {
+	fs := flag.NewFlagSet("integrate", flag.ContinueOnError)
+	i := integrate{fs: fs}
+	fs.StringVar(&i.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&i.forceDetect, "force-detect", false, "rerun the detection scripts, ignoring the cached results")
+	return &i
+}
+
+func (i *integrate) Parse(args []string) error {
+	if err := i.fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := openWorkspace(i.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	detections, err := integration.Detect(w, i.forceDetect)
+	if err != nil {
+		return fmt.Errorf("integrate: %w", err)
+	}
+
+	integrations, err := integration.Integrate(w, detections)
+	if err != nil {
+		return fmt.Errorf("integrate: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "APP\tVERSION\tCAPABILITY\tTARGET\tSTATUS\n")
+	for _, app := range integrations {
+		for _, r := range app.Results {
+			status := "ok"
+			if !r.Success {
+				status = "failed: " + strings.Join(strings.Fields(r.Error), " ")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", app.Name, app.Version, r.Capability, r.TargetName, status)
+		}
+	}
+	tw.Flush()
+
+	return nil
+}
+
+func (*integrate) Name() string { return "integrate" }
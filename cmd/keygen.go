@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zhk-kk/raftpm/pkg/signing"
+)
+
+type keyGen struct {
+	fs      *flag.FlagSet
+	outPath string
+}
+
+func NewKeyGen() *keyGen {
+	fs := flag.NewFlagSet("key-gen", flag.ContinueOnError)
+	kg := keyGen{fs: fs}
+	fs.StringVar(&kg.outPath, "out", "", "path of the key pair; `.key` and `.pub` extensions are appended")
+	return &kg
+}
+
+func (kg *keyGen) Parse(args []string) error {
+	if err := kg.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if kg.outPath == "" {
+		return fmt.Errorf("key-gen: %w: `-out`", ErrArgumentMustBeSpecified)
+	}
+
+	pub, priv, err := signing.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	// The private key must not be readable by anyone else.
+	if err := os.WriteFile(kg.outPath+".key", signing.EncodePrivateKey(priv), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(kg.outPath+".pub", signing.EncodePublicKey(pub), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("generated key `%s`\n", signing.KeyID(pub))
+
+	return nil
+}
+
+func (*keyGen) Name() string { return "key-gen" }
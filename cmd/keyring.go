@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/zhk-kk/raftpm/pkg/signing"
+)
+
+type keyringAdd struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	name          string
+}
+
+func NewKeyringAdd() *keyringAdd {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	ka := keyringAdd{fs: fs}
+	fs.StringVar(&ka.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.StringVar(&ka.name, "name", "", "name to trust the key under")
+	return &ka
+}
+
+func (ka *keyringAdd) Parse(args []string) error {
+	if err := ka.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if ka.name == "" {
+		return fmt.Errorf("keyring add: %w: `-name`", ErrArgumentMustBeSpecified)
+	}
+	if ka.fs.NArg() == 0 {
+		return fmt.Errorf("keyring add: %w: public key file", ErrExpectedPath)
+	}
+
+	rawKey, err := os.ReadFile(ka.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	pub, err := signing.ParsePublicKey(rawKey)
+	if err != nil {
+		return fmt.Errorf("keyring add: %w", err)
+	}
+
+	w, err := openWorkspace(ka.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	if err := w.Keyring().Add(ka.name, pub); err != nil {
+		return fmt.Errorf("keyring add: %w", err)
+	}
+
+	fmt.Printf("trusted key `%s` as `%s`\n", signing.KeyID(pub), ka.name)
+
+	return nil
+}
+
+func (*keyringAdd) Name() string { return "add" }
+
+type keyringRemove struct {
+	fs            *flag.FlagSet
+	workspacePath string
+}
+
+func NewKeyringRemove() *keyringRemove {
+	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
+	kr := keyringRemove{fs: fs}
+	fs.StringVar(&kr.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	return &kr
+}
+
+func (kr *keyringRemove) Parse(args []string) error {
+	if err := kr.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if kr.fs.NArg() == 0 {
+		return fmt.Errorf("keyring remove: %w: key name", ErrArgumentMustBeSpecified)
+	}
+
+	w, err := openWorkspace(kr.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	if err := w.Keyring().Remove(kr.fs.Arg(0)); err != nil {
+		return fmt.Errorf("keyring remove: %w", err)
+	}
+
+	return nil
+}
+
+func (*keyringRemove) Name() string { return "remove" }
+
+type keyringList struct {
+	fs            *flag.FlagSet
+	workspacePath string
+}
+
+func NewKeyringList() *keyringList {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	kl := keyringList{fs: fs}
+	fs.StringVar(&kl.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	return &kl
+}
+
+func (kl *keyringList) Parse(args []string) error {
+	if err := kl.fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := openWorkspace(kl.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	keys, err := w.Keyring().Keys()
+	if err != nil {
+		return fmt.Errorf("keyring list: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tID\n")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", key.Name, key.ID)
+	}
+	tw.Flush()
+
+	fmt.Printf("\nsignature policy: %s\n", w.SignaturePolicy())
+
+	return nil
+}
+
+func (*keyringList) Name() string { return "list" }
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+type list struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	jsonOutput    bool
+}
+
+func NewList() *list {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	l := list{fs: fs}
+	fs.StringVar(&l.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&l.jsonOutput, "json", false, "print the store index in the JSON format")
+	return &l
+}
+
+// listEntry is the record of an installed package, along with it's current state.
+type listEntry struct {
+	store.IndexEntry
+	Active bool `json:"active"`
+	Pinned bool `json:"pinned"`
+}
+
+func (l *list) Parse(args []string) error {
+	if err := l.fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := openWorkspace(l.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	entries := []listEntry{}
+	for _, e := range w.Store().Index() {
+		entry := listEntry{IndexEntry: e, Active: true}
+		// Integration scripts packages have a single version, which is always active.
+		if e.Type == manifest.TypeBinaryPkg {
+			active, _, err := w.Store().ActiveVersion(e.Name)
+			if err != nil {
+				return fmt.Errorf("list: %w", err)
+			}
+			entry.Active = active == e.Version
+			entry.Pinned = w.Store().IsPinned(e.Name)
+		}
+		entries = append(entries, entry)
+	}
+
+	if l.jsonOutput {
+		out, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NAME\tVERSION\tTYPE\tREASON\tSTATE\tINSTALLED\n")
+	for _, e := range entries {
+		installedAt := "unknown"
+		if !e.InstalledAt.IsZero() {
+			installedAt = e.InstalledAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Name, e.Version, e.Type, e.Reason, e.state(), installedAt)
+	}
+	tw.Flush()
+
+	return nil
+}
+
+func (*list) Name() string { return "list" }
+
+// state describes whether the version is active and pinned.
+func (e listEntry) state() string {
+	switch {
+	case e.Active && e.Pinned:
+		return "active, pinned"
+	case e.Active:
+		return "active"
+	default:
+		return "-"
+	}
+}
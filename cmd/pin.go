@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+type pin struct {
+	fs            *flag.FlagSet
+	workspacePath string
+}
+
+func NewPin() *pin {
+	fs := flag.NewFlagSet("pin", flag.ContinueOnError)
+	p := pin{fs: fs}
+	fs.StringVar(&p.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	return &p
+}
+
+func (p *pin) Parse(args []string) error {
+	if err := p.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if p.fs.NArg() == 0 {
+		return fmt.Errorf("pin: %w: package name", ErrArgumentMustBeSpecified)
+	}
+	name := p.fs.Arg(0)
+
+	w, err := openWorkspace(p.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	// Only the installed packages could be pinned.
+	if _, err := lookupInstalled(w.Store(), name, ""); err != nil && !errors.Is(err, ErrAmbiguousPackage) {
+		return fmt.Errorf("pin: %w", err)
+	}
+
+	if err := w.Store().Pin(name); errors.Is(err, store.ErrNoActiveVersion) {
+		return fmt.Errorf("pin: %w (use `switch %s <version>` to activate one)", err, name)
+	} else if err != nil {
+		return fmt.Errorf("pin: %w", err)
+	}
+
+	active, _, err := w.Store().ActiveVersion(name)
+	if err != nil {
+		return fmt.Errorf("pin: %w", err)
+	}
+	fmt.Printf("pinned %s to %s\n", name, active)
+
+	return nil
+}
+
+func (*pin) Name() string { return "pin" }
+
+type unpin struct {
+	fs            *flag.FlagSet
+	workspacePath string
+}
+
+func NewUnpin() *unpin {
+	fs := flag.NewFlagSet("unpin", flag.ContinueOnError)
+	u := unpin{fs: fs}
+	fs.StringVar(&u.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	return &u
+}
+
+func (u *unpin) Parse(args []string) error {
+	if err := u.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if u.fs.NArg() == 0 {
+		return fmt.Errorf("unpin: %w: package name", ErrArgumentMustBeSpecified)
+	}
+	name := u.fs.Arg(0)
+
+	w, err := openWorkspace(u.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	if err := w.Store().Unpin(name); err != nil {
+		return fmt.Errorf("unpin: %w", err)
+	}
+
+	fmt.Printf("unpinned %s\n", name)
+
+	return nil
+}
+
+func (*unpin) Name() string { return "unpin" }
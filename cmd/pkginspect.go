@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/pkg/paths"
+)
+
+type pkgInspect struct {
+	fs         *flag.FlagSet
+	jsonOutput bool
+}
+
+func NewPkgInspect() *pkgInspect {
+	fs := flag.NewFlagSet("pkg-inspect", flag.ContinueOnError)
+	pi := pkgInspect{fs: fs}
+	fs.BoolVar(&pi.jsonOutput, "json", false, "print the information in the JSON format")
+	return &pi
+}
+
+// pkgInspectInfo is the information printed about the package.
+type pkgInspectInfo struct {
+	Name          string              `json:"name"`
+	Type          string              `json:"type"`
+	Version       string              `json:"version"`
+	RaftpmVersion string              `json:"raftpmVersion"`
+	Arch          map[string][]string `json:"arch,omitempty"`
+	About         map[string]string   `json:"about,omitempty"`
+	Manifest      json.RawMessage     `json:"manifest"`
+	Files         []pkgInspectFile    `json:"files"`
+}
+
+type pkgInspectFile struct {
+	Path           string `json:"path"`
+	Mode           string `json:"mode"`
+	Size           uint64 `json:"size"`
+	CompressedSize uint64 `json:"compressedSize"`
+}
+
+func (pi *pkgInspect) Parse(args []string) error {
+	if err := pi.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if pi.fs.NArg() == 0 {
+		return fmt.Errorf("pkg-inspect: %w: package file", ErrExpectedPath)
+	}
+
+	file, err := os.Open(pi.fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	compiled, err := pkg.OpenPackage(file, stat.Size())
+	if err != nil {
+		return fmt.Errorf("pkg-inspect: %w", err)
+	}
+
+	info, err := newPkgInspectInfo(compiled)
+	if err != nil {
+		return fmt.Errorf("pkg-inspect: %w", err)
+	}
+
+	if pi.jsonOutput {
+		out, err := json.MarshalIndent(info, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	printPkgInspectInfo(info)
+
+	return nil
+}
+
+func (*pkgInspect) Name() string { return "pkg-inspect" }
+
+// newPkgInspectInfo gathers the information about the compiled package.
+func newPkgInspectInfo(compiled *pkg.Package) (pkgInspectInfo, error) {
+	info := pkgInspectInfo{
+		Version:       compiled.CommonInfo().PkgVersion.String(),
+		RaftpmVersion: compiled.CommonInfo().RaftpmVersion.String(),
+	}
+
+	switch pkgManifest := compiled.Manifest().(type) {
+	case manifest.BinaryPkg:
+		info.Name = pkgManifest.Name
+		info.Type = manifest.TypeBinaryPkg
+		info.Arch = pkgManifest.Arch
+		info.About = pkgManifest.About
+	case manifest.IntegrationScriptsPkg:
+		info.Name = pkgManifest.TargetName
+		info.Type = manifest.TypeIntegrationScriptsPkg
+	}
+
+	rawManifest, err := compiled.ReadMetadata(paths.CompiledManifestName)
+	if err != nil {
+		return info, err
+	}
+
+	// Indent the manifest, so that it's readable regardless of how it was compiled.
+	indented := bytes.NewBuffer([]byte{})
+	if err := json.Indent(indented, rawManifest, "", "    "); err != nil {
+		return info, err
+	}
+	info.Manifest = indented.Bytes()
+
+	for _, dir := range []string{paths.CopyDataDir, paths.IntegrationScriptsDir, paths.MetadataDir} {
+		for _, e := range compiled.Entries(dir) {
+			info.Files = append(info.Files, pkgInspectFile{
+				Path:           e.ArchivePath(),
+				Mode:           e.Mode.String(),
+				Size:           e.Size,
+				CompressedSize: e.CompressedSize,
+			})
+		}
+	}
+
+	return info, nil
+}
+
+// printPkgInspectInfo prints the information in a human-readable format.
+func printPkgInspectInfo(info pkgInspectInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "name:\t%s\n", info.Name)
+	fmt.Fprintf(tw, "type:\t%s\n", info.Type)
+	fmt.Fprintf(tw, "version:\t%s\n", info.Version)
+	fmt.Fprintf(tw, "raftpmVersion:\t%s\n", info.RaftpmVersion)
+	for _, key := range sortedKeys(info.Arch) {
+		fmt.Fprintf(tw, "arch.%s:\t%s\n", key, strings.Join(info.Arch[key], ", "))
+	}
+	for _, key := range sortedKeys(info.About) {
+		fmt.Fprintf(tw, "about.%s:\t%s\n", key, info.About[key])
+	}
+	tw.Flush()
+
+	fmt.Printf("\nmanifest:\n%s\n\nfiles:\n", info.Manifest)
+
+	fmt.Fprintf(tw, "MODE\tSIZE\tCOMPRESSED\tPATH\n")
+	for _, f := range info.Files {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", f.Mode, f.Size, f.CompressedSize, f.Path)
+	}
+	tw.Flush()
+}
+
+// sortedKeys returns the keys of the map in a sorted order.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/signing"
+)
+
+type pkgSign struct {
+	fs       *flag.FlagSet
+	keyPath  string
+	outPath  string
+	detached bool
+}
+
+func NewPkgSign() *pkgSign {
+	fs := flag.NewFlagSet("pkg-sign", flag.ContinueOnError)
+	ps := pkgSign{fs: fs}
+	fs.StringVar(&ps.keyPath, "key", "", "path to the private key")
+	fs.StringVar(&ps.outPath, "out", "", "output path (defaults to signing in place, or to `<package>.sig` when detached)")
+	fs.BoolVar(&ps.detached, "detached", false, "write the signature to a separate file instead of embedding it")
+	return &ps
+}
+
+func (ps *pkgSign) Parse(args []string) error {
+	if err := ps.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if ps.keyPath == "" {
+		return fmt.Errorf("pkg-sign: %w: `-key`", ErrArgumentMustBeSpecified)
+	}
+	if ps.fs.NArg() == 0 {
+		return fmt.Errorf("pkg-sign: %w: package file", ErrExpectedPath)
+	}
+	pkgPath := ps.fs.Arg(0)
+
+	rawKey, err := os.ReadFile(ps.keyPath)
+	if err != nil {
+		return err
+	}
+	priv, err := signing.ParsePrivateKey(rawKey)
+	if err != nil {
+		return fmt.Errorf("pkg-sign: %w", err)
+	}
+
+	file, err := os.Open(pkgPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	compiled, err := pkg.OpenPackage(file, stat.Size())
+	if err != nil {
+		return fmt.Errorf("pkg-sign: %w", err)
+	}
+
+	sig, err := signing.Sign(compiled, priv)
+	if err != nil {
+		return fmt.Errorf("pkg-sign: %w", err)
+	}
+
+	if ps.detached {
+		outPath := ps.outPath
+		if outPath == "" {
+			outPath = pkgPath + ".sig"
+		}
+
+		rawSig, err := sig.Encode()
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(outPath, rawSig, 0644)
+	}
+
+	// Write the signed package next to the destination, and move it into place once complete.
+	outPath := ps.outPath
+	if outPath == "" {
+		outPath = pkgPath
+	}
+
+	out, err := os.CreateTemp(path.Dir(outPath), ".pkg-sign-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	if err := signing.Embed(compiled, sig, out); err != nil {
+		return fmt.Errorf("pkg-sign: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(out.Name(), stat.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), outPath)
+}
+
+func (*pkgSign) Name() string { return "pkg-sign" }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+)
+
+type switchVersion struct {
+	fs            *flag.FlagSet
+	workspacePath string
+}
+
+func NewSwitch() *switchVersion {
+	fs := flag.NewFlagSet("switch", flag.ContinueOnError)
+	s := switchVersion{fs: fs}
+	fs.StringVar(&s.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	return &s
+}
+
+func (s *switchVersion) Parse(args []string) error {
+	if err := s.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if s.fs.NArg() < 2 {
+		return fmt.Errorf("switch: %w: package name and version", ErrArgumentMustBeSpecified)
+	}
+	name, version := s.fs.Arg(0), s.fs.Arg(1)
+
+	w, err := openWorkspace(s.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	p, err := lookupInstalled(w.Store(), name, version)
+	if err != nil {
+		return fmt.Errorf("switch: %w", err)
+	}
+
+	if err := w.Activate(p); err != nil {
+		return fmt.Errorf("switch: %w", err)
+	}
+
+	fmt.Printf("switched %s to %s\n", p.Name(), p.CommonInfo.PkgVersion)
+
+	return nil
+}
+
+func (*switchVersion) Name() string { return "switch" }
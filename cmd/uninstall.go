@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+var (
+	ErrPackageNotInstalled  = errors.New("package is not installed")
+	ErrAmbiguousPackage     = errors.New("multiple versions of the package are installed, please specify one")
+	ErrPackageStillRequired = errors.New("package is still required by other packages")
+)
+
+type uninstall struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	force         bool
+}
+
+func NewUninstall() *uninstall {
+	fs := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	u := uninstall{fs: fs}
+	fs.StringVar(&u.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&u.force, "force", false, "remove the package even if other packages depend on it")
+	return &u
+}
+
+func (u *uninstall) Parse(args []string) error {
+	if err := u.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if u.fs.NArg() == 0 {
+		return fmt.Errorf("uninstall: %w: package name", ErrArgumentMustBeSpecified)
+	}
+	name := u.fs.Arg(0)
+	version := u.fs.Arg(1)
+
+	w, err := openWorkspace(u.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	p, err := lookupInstalled(w.Store(), name, version)
+	if err != nil {
+		return fmt.Errorf("uninstall: %w", err)
+	}
+
+	// Check that nothing depends on the package.
+	dependents, err := w.Store().Dependents(p)
+	if err != nil {
+		return fmt.Errorf("uninstall: %w", err)
+	}
+	if len(dependents) != 0 {
+		names := make([]string, 0, len(dependents))
+		for _, d := range dependents {
+			names = append(names, fmt.Sprintf("%s %s", d.Name(), d.CommonInfo.PkgVersion))
+		}
+		if !u.force {
+			return fmt.Errorf("uninstall: %w: %s", ErrPackageStillRequired, strings.Join(names, ", "))
+		}
+		fmt.Printf("warning: `%s` is still required by: %s\n", name, strings.Join(names, ", "))
+	}
+
+	if err := w.Uninstall(p); err != nil {
+		return fmt.Errorf("uninstall: %w", err)
+	}
+
+	fmt.Printf("uninstalled %s %s\n", p.Name(), p.CommonInfo.PkgVersion)
+
+	if p.Active {
+		if active, ok, err := w.Store().ActiveVersion(name); err != nil {
+			return fmt.Errorf("uninstall: %w", err)
+		} else if ok {
+			fmt.Printf("switched %s to %s\n", p.Name(), active)
+		}
+	}
+
+	return nil
+}
+
+func (*uninstall) Name() string { return "uninstall" }
+
+// lookupInstalled finds the single installed package with the provided name.
+// If version is empty, the package must be installed in only one version.
+func lookupInstalled(s *store.Store, name string, version string) (store.Package, error) {
+	packages, err := s.Lookup(name)
+	if err != nil {
+		return store.Package{}, err
+	}
+
+	var matching []store.Package
+	for _, p := range packages {
+		if version == "" || p.CommonInfo.PkgVersion.String() == version {
+			matching = append(matching, p)
+		}
+	}
+
+	switch len(matching) {
+	case 0:
+		if version != "" {
+			return store.Package{}, fmt.Errorf("%w: `%s` %s", ErrPackageNotInstalled, name, version)
+		}
+		return store.Package{}, fmt.Errorf("%w: `%s`", ErrPackageNotInstalled, name)
+	case 1:
+		return matching[0], nil
+	default:
+		return store.Package{}, fmt.Errorf("%w: `%s`", ErrAmbiguousPackage, name)
+	}
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/workspace"
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+var (
+	ErrVerificationFailed = errors.New("installed packages don't match their hashes")
+	ErrArchiveNotCached   = errors.New("original package file isn't cached, enable cache.keepArchives to keep it")
+)
+
+type verify struct {
+	fs            *flag.FlagSet
+	workspacePath string
+	repair        bool
+}
+
+func NewVerify() *verify {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	v := verify{fs: fs}
+	fs.StringVar(&v.workspacePath, "workspace", "", "path to the workspace (defaults to the one raftpm is running from)")
+	fs.BoolVar(&v.repair, "repair", false, "restore the broken packages from their cached package files")
+	return &v
+}
+
+func (v *verify) Parse(args []string) error {
+	if err := v.fs.Parse(args); err != nil {
+		return err
+	}
+
+	w, err := openWorkspace(v.workspacePath)
+	if err != nil {
+		return err
+	}
+
+	// Verify all the installed packages, unless one is specified.
+	var packages []store.Package
+	if v.fs.NArg() != 0 {
+		p, err := lookupInstalled(w.Store(), v.fs.Arg(0), v.fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("verify: %w", err)
+		}
+		packages = []store.Package{p}
+	} else if packages, err = w.Store().Packages(); err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "PACKAGE\tPROBLEM\tPATH\tDETAILS\n")
+
+	broken := 0
+	var unverified, repaired, unrepaired []string
+	for _, p := range packages {
+		problems, err := w.Store().Verify(p)
+		if errors.Is(err, pkg.ErrNoHashList) {
+			unverified = append(unverified, describePackage(p))
+			continue
+		} else if err != nil {
+			return fmt.Errorf("verify: %s: %w", describePackage(p), err)
+		}
+
+		for _, problem := range problems {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", describePackage(p), problem.Kind, problem.Path, problem.Details)
+		}
+		if len(problems) == 0 {
+			continue
+		}
+		broken++
+
+		if v.repair {
+			if err := repairPackage(w, p, problems); err != nil {
+				unrepaired = append(unrepaired, fmt.Sprintf("%s: %s", describePackage(p), err))
+			} else {
+				repaired = append(repaired, describePackage(p))
+				broken--
+			}
+		}
+	}
+	tw.Flush()
+
+	for _, description := range unverified {
+		fmt.Printf("skipped %s: installed without a hash list\n", description)
+	}
+	for _, description := range repaired {
+		fmt.Printf("repaired %s\n", description)
+	}
+	for _, message := range unrepaired {
+		fmt.Printf("couldn't repair %s\n", message)
+	}
+
+	if broken != 0 {
+		return fmt.Errorf("verify: %w: %d of %d packages are broken", ErrVerificationFailed, broken, len(packages))
+	}
+	fmt.Printf("verified %d packages\n", len(packages)-len(unverified))
+
+	return nil
+}
+
+func (*verify) Name() string { return "verify" }
+
+// repairPackage restores the package from it's cached package file, and checks that it's intact afterwards.
+func repairPackage(w *workspace.Workspace, p store.Package, problems []store.Problem) error {
+	archivePath, ok, err := w.Archive(p)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrArchiveNotCached
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := w.Store().Repair(p, problems, file, stat.Size()); err != nil {
+		return err
+	}
+
+	remaining, err := w.Store().Verify(p)
+	if err != nil {
+		return err
+	}
+	if len(remaining) != 0 {
+		return fmt.Errorf("%d problems remain after the repair", len(remaining))
+	}
+
+	return nil
+}
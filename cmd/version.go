@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/zhk-kk/raftpm/global"
+)
+
+type version struct {
+	fs *flag.FlagSet
+}
+
+func NewVersion() *version {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	return &version{fs: fs}
+}
+
+func (v *version) Parse(args []string) error {
+	if err := v.fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Println(global.Version())
+
+	return nil
+}
+
+func (*version) Name() string { return "version" }
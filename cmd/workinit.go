@@ -35,6 +35,10 @@ func (wi *workspaceInit) Parse(args []string) error {
 		return fmt.Errorf("couldn't initialize the workspace: %w", err)
 	}
 
+	if err := w.Load(); err != nil {
+		return err
+	}
+
 	err := w.Editor().
 		Portable(wi.portable).
 		ApplyChanges()
@@ -43,10 +47,6 @@ func (wi *workspaceInit) Parse(args []string) error {
 		return err
 	}
 
-	if err := w.Load(); err != nil {
-		return err
-	}
-
 	return nil
 }
 
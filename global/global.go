@@ -1,13 +1,29 @@
 package global
 
 import (
+	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+
+	"github.com/blang/semver/v4"
 )
 
 var Global *global = nil
 
+// version is the version of raftpm. It's meant to be set at build time:
+// `go build -ldflags "-X github.com/zhk-kk/raftpm/global.version=1.2.3"`.
+var version = "0.1.0"
+
+// Version returns the version of the running raftpm instance.
+func Version() semver.Version {
+	v, err := semver.ParseTolerant(version)
+	if err != nil {
+		panic(fmt.Sprintf("[BUG]: raftpm was built with an invalid version `%s`: %s", version, err))
+	}
+	return v
+}
+
 type global struct {
 	runningExecutablePath string
 	runningExecutableDir  string
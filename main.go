@@ -19,10 +19,37 @@ func main() {
 	resolver := cmd.NewResolver([]cmd.Subcommand{
 		cmd.NewNested("develop", []cmd.Subcommand{
 			cmd.NewPkgCompile(),
+			cmd.NewPkgInspect(),
 			cmd.NewWorkspaceInit(),
 			cmd.NewSelfPackage(),
+			cmd.NewKeyGen(),
+			cmd.NewPkgSign(),
 		}),
 		cmd.NewDeploy(),
+		cmd.NewInstall(),
+		cmd.NewUninstall(),
+		cmd.NewList(),
+		cmd.NewSwitch(),
+		cmd.NewPin(),
+		cmd.NewUnpin(),
+		cmd.NewGC(),
+		cmd.NewVerify(),
+		cmd.NewDetect(),
+		cmd.NewIntegrate(),
+		cmd.NewEnv(),
+		cmd.NewActivate(),
+		cmd.NewVersion(),
+		cmd.NewNested("keyring", []cmd.Subcommand{
+			cmd.NewKeyringAdd(),
+			cmd.NewKeyringRemove(),
+			cmd.NewKeyringList(),
+		}),
+		cmd.NewNested("config", []cmd.Subcommand{
+			cmd.NewConfigGet(),
+			cmd.NewConfigSet(),
+			cmd.NewConfigUnset(),
+			cmd.NewConfigList(),
+		}),
 	})
 
 	// Parse the arguments, running requested modules.
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+)
+
+var (
+	ErrUnknownArchKey     = errors.New("unknown architecture key")
+	ErrMissingArch        = errors.New("architecture must be specified")
+	ErrUnsupportedArch    = errors.New("unsupported architecture")
+	ErrUnsupportedHost    = errors.New("host architecture isn't supported by raftpm")
+	ErrHostArchMismatched = errors.New("package wasn't built for this host")
+)
+
+const (
+	ArchKeyCpu = "cpu"
+	ArchKeyOs  = "os"
+)
+
+// goArchCpu maps the values of runtime.GOARCH onto raftpm's cpu names.
+var goArchCpu = map[string]string{
+	"amd64": "x86_64",
+	"386":   "x86",
+	"arm64": "aarch64",
+	"arm":   "aarch32",
+}
+
+// goArchOs maps the values of runtime.GOOS onto raftpm's os names.
+var goArchOs = map[string]string{
+	"linux":     "linux",
+	"darwin":    "macos",
+	"freebsd":   "bsd",
+	"openbsd":   "bsd",
+	"netbsd":    "bsd",
+	"dragonfly": "bsd",
+}
+
+// HostArch returns raftpm's names of the host cpu and os.
+func HostArch() (cpu string, os string, err error) {
+	cpu, ok := goArchCpu[runtime.GOARCH]
+	if !ok {
+		return "", "", fmt.Errorf("%w: cpu `%s`", ErrUnsupportedHost, runtime.GOARCH)
+	}
+	os, ok = goArchOs[runtime.GOOS]
+	if !ok {
+		return "", "", fmt.Errorf("%w: os `%s`", ErrUnsupportedHost, runtime.GOOS)
+	}
+	return cpu, os, nil
+}
+
+// validateArch verifies that the architecture only lists the allowed cpu and os values.
+func validateArch(arch map[string][]string) error {
+	for key, values := range arch {
+		var allowed []string
+		switch key {
+		case ArchKeyCpu:
+			allowed = AllowedArchCpu
+		case ArchKeyOs:
+			allowed = AllowedArchOs
+		default:
+			return fmt.Errorf("%w: `%s`", ErrUnknownArchKey, key)
+		}
+
+		for _, v := range values {
+			if !slices.Contains(allowed, v) {
+				return fmt.Errorf("%w: %s `%s`, expected one of: %s",
+					ErrUnsupportedArch, key, v, strings.Join(allowed, ", "))
+			}
+		}
+	}
+
+	for _, key := range []string{ArchKeyCpu, ArchKeyOs} {
+		if len(arch[key]) == 0 {
+			return fmt.Errorf("%w: `%s`", ErrMissingArch, key)
+		}
+	}
+
+	return nil
+}
+
+// CheckHostArch returns an error if the binary package wasn't built for the host.
+func CheckHostArch(binPkg manifest.BinaryPkg) error {
+	cpu, os, err := HostArch()
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(binPkg.Arch[ArchKeyCpu], cpu) || !slices.Contains(binPkg.Arch[ArchKeyOs], os) {
+		return fmt.Errorf("%w: built for %s/%s, but the host is %s/%s", ErrHostArchMismatched,
+			strings.Join(binPkg.Arch[ArchKeyCpu], ","), strings.Join(binPkg.Arch[ArchKeyOs], ","), cpu, os)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/zhk-kk/raftpm/pkg/paths"
+)
+
+var (
+	ErrHashMismatch  = errors.New("file doesn't match it's recorded hash")
+	ErrUnhashedFile  = errors.New("file isn't in the hash list")
+	ErrMissingFile   = errors.New("hashed file is missing")
+	ErrNoHashList    = errors.New("package has no hash list")
+	ErrBadHashDigest = errors.New("malformed hash")
+)
+
+// FileHash is the recorded hash of a single package file.
+type FileHash struct {
+	SHA256 string      `json:"sha256"`
+	Size   int64       `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+}
+
+// Hashes maps archive paths of the package data files, such as `cpdata/bin/app`, to their hashes.
+type Hashes map[string]FileHash
+
+// NewFileHash computes the hash of the file contents.
+func NewFileHash(contents []byte, mode fs.FileMode) FileHash {
+	sum := sha256.Sum256(contents)
+	return FileHash{SHA256: hex.EncodeToString(sum[:]), Size: int64(len(contents)), Mode: mode.Perm()}
+}
+
+// Check compares the computed digest and size of a file against the recorded hash.
+func (h FileHash) Check(archivePath string, digest []byte, size int64) error {
+	if h.Size != size || h.SHA256 != hex.EncodeToString(digest) {
+		return fmt.Errorf("%w: `%s`: expected sha256 %s (%d bytes), got %x (%d bytes)",
+			ErrHashMismatch, archivePath, h.SHA256, h.Size, digest, size)
+	}
+	return nil
+}
+
+// ParseHashes parses the decoded hash list.
+func ParseHashes(raw []byte) (Hashes, error) {
+	h := Hashes{}
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, fmt.Errorf("couldn't parse the hash list: %w", err)
+	}
+	for p, fh := range h {
+		if digest, err := hex.DecodeString(fh.SHA256); err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("%w: `%s`", ErrBadHashDigest, p)
+		}
+	}
+	return h, nil
+}
+
+// encodeHashes encodes the hash list as a metadata file.
+func encodeHashes(h Hashes) ([]byte, error) {
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	return encodeMetadataFile(raw, false)
+}
+
+// Hashes returns the hash list of the package.
+// If the package was compiled without one, ErrNoHashList is returned.
+func (p *Package) Hashes() (Hashes, error) {
+	raw, err := p.ReadMetadata(paths.HashesName)
+	if errors.Is(err, ErrEntryNotFound) {
+		return nil, ErrNoHashList
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseHashes(raw)
+}
@@ -13,6 +13,12 @@ var (
 	ErrUnknownPkgType = errors.New("unknown package type")
 )
 
+// Package types, as written in the `type` field of the manifest.
+const (
+	TypeBinaryPkg             = "binPkg"
+	TypeIntegrationScriptsPkg = "isPkg"
+)
+
 func ParseManifest(raw []byte, outPkgCommonInfo *PkgCommonInfo) (interface{}, error) {
 	var m map[string]interface{}
 	if err := json.Unmarshal(raw, &m); err != nil {
@@ -51,13 +57,13 @@ func ParseManifest(raw []byte, outPkgCommonInfo *PkgCommonInfo) (interface{}, er
 
 	// Parse the rest of the manifest according to the package type.
 	switch pkgType {
-	case "binPkg":
+	case TypeBinaryPkg:
 		result := BinaryPkg{}
 		if err := json.Unmarshal(raw, &result); err != nil {
 			return nil, err
 		}
 		return result, nil
-	case "isPkg":
+	case TypeIntegrationScriptsPkg:
 		result := IntegrationScriptsPkg{}
 		if err := json.Unmarshal(raw, &result); err != nil {
 			return nil, err
@@ -68,6 +74,41 @@ func ParseManifest(raw []byte, outPkgCommonInfo *PkgCommonInfo) (interface{}, er
 	}
 }
 
+// MarshalManifest encodes the manifest along with the common info, so that it could be parsed by ParseManifest.
+func MarshalManifest(pkgManifest interface{}, info PkgCommonInfo) ([]byte, error) {
+	pkgType, err := PkgType(pkgManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(pkgManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	m["raftpmVersion"] = info.RaftpmVersion.String()
+	m["version"] = info.PkgVersion.String()
+	m["type"] = pkgType
+
+	return json.Marshal(m)
+}
+
+// PkgType returns the type of the parsed manifest.
+func PkgType(pkgManifest interface{}) (string, error) {
+	switch pkgManifest.(type) {
+	case BinaryPkg:
+		return TypeBinaryPkg, nil
+	case IntegrationScriptsPkg:
+		return TypeIntegrationScriptsPkg, nil
+	default:
+		return "", fmt.Errorf("%w `%T`", ErrUnknownPkgType, pkgManifest)
+	}
+}
+
 type PkgCommonInfo struct {
 	PkgVersion    semver.Version
 	RaftpmVersion semver.Version
@@ -79,6 +120,33 @@ type BinaryPkg struct {
 	About       map[string]string         `json:"about"`
 	BinRegistry map[string]common.PkgPath `json:"binRegistry"`
 	BinShellExe map[string]string         `json:"binShellExe"`
+
+	// Dependencies maps names of the required packages to semver ranges.
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+
+	// Capabilities lists the integrations requested from the detected targets, e.g. `desktopApp`.
+	Capabilities []CapabilityRequest `json:"capabilities,omitempty"`
+	// Icon is an optional icon, passed to the capability scripts.
+	Icon *common.PkgPath `json:"icon,omitempty"`
+}
+
+type CapabilityRequest struct {
+	Capability string `json:"capability"`
+	// Exe is the name of the shell executable (a key of BinShellExe) the capability applies to.
+	Exe string `json:"exe"`
+}
+
+// DependencyRanges parses the version ranges of all the dependencies.
+func (p BinaryPkg) DependencyRanges() (map[string]semver.Range, error) {
+	ranges := make(map[string]semver.Range, len(p.Dependencies))
+	for name, rawRange := range p.Dependencies {
+		r, err := ParseDependencyRange(rawRange)
+		if err != nil {
+			return nil, fmt.Errorf("dependency `%s`: %w", name, err)
+		}
+		ranges[name] = r
+	}
+	return ranges, nil
 }
 
 type IntegrationScriptsPkg struct {
@@ -92,3 +160,12 @@ type CapabilityScriptDesc struct {
 	Capability string         `json:"capability"`
 	Path       common.PkgPath `json:"path"`
 }
+
+// ParseDependencyRange parses the semver range of a dependency.
+// Both `*` and an empty string match any version.
+func ParseDependencyRange(rawRange string) (semver.Range, error) {
+	if rawRange == "" || rawRange == "*" {
+		return func(semver.Version) bool { return true }, nil
+	}
+	return semver.ParseRange(rawRange)
+}
@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/pkg/paths"
+	"github.com/zhk-kk/raftpm/utils/archiver"
+)
+
+var (
+	ErrUnsafeEntryPath = errors.New("package entry path escapes it's directory")
+	ErrEntryNotFound   = errors.New("package entry not found")
+)
+
+// Package is a handle to a compiled package. It is the inverse of CompileTemplate.
+type Package struct {
+	comment       string
+	manifest      interface{}
+	pkgCommonInfo manifest.PkgCommonInfo
+	entries       []Entry
+}
+
+// Entry describes a single file or directory of a compiled package.
+type Entry struct {
+	// Dir is the top-level directory of the entry, such as `cpdata`, `iscripts` or `metadata`.
+	Dir string
+	// Path is the path of the entry relative to Dir.
+	Path           string
+	Mode           fs.FileMode
+	Size           uint64
+	CompressedSize uint64
+
+	file *zip.File
+}
+
+// ArchivePath returns the full path of the entry inside the archive.
+func (e Entry) ArchivePath() string { return e.Dir + "/" + e.Path }
+
+func (e Entry) IsDir() bool { return e.Mode.IsDir() }
+
+// OpenPackage opens the compiled package, reading and parsing it's manifest.
+func OpenPackage(r io.ReaderAt, size int64) (*Package, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the package: %w", err)
+	}
+
+	p := Package{comment: zr.Comment}
+
+	for _, f := range zr.File {
+		dir, relativePath, _ := strings.Cut(strings.TrimSuffix(f.Name, "/"), "/")
+		if relativePath == "" {
+			continue
+		}
+		if !filepath.IsLocal(relativePath) {
+			return nil, fmt.Errorf("%w: `%s`", ErrUnsafeEntryPath, f.Name)
+		}
+
+		p.entries = append(p.entries, Entry{
+			Dir:            dir,
+			Path:           relativePath,
+			Mode:           f.Mode(),
+			Size:           f.UncompressedSize64,
+			CompressedSize: f.CompressedSize64,
+			file:           f,
+		})
+	}
+
+	p.manifest, err = ReadPackageManifest(zr, &p.pkgCommonInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// Manifest returns the parsed manifest, which is either manifest.BinaryPkg or manifest.IntegrationScriptsPkg.
+func (p *Package) Manifest() interface{} { return p.manifest }
+
+func (p *Package) CommonInfo() manifest.PkgCommonInfo { return p.pkgCommonInfo }
+
+// DataDir returns the top-level directory holding the data of the package:
+// `cpdata` for binary packages, `iscripts` for integration scripts packages.
+func (p *Package) DataDir() string {
+	switch p.manifest.(type) {
+	case manifest.IntegrationScriptsPkg:
+		return paths.IntegrationScriptsDir
+	default:
+		return paths.CopyDataDir
+	}
+}
+
+// Entries returns all the entries inside the provided top-level directory.
+func (p *Package) Entries(dir string) []Entry {
+	var result []Entry
+	for _, e := range p.entries {
+		if e.Dir == dir {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// DataEntries returns all the entries of the package data directory.
+func (p *Package) DataEntries() []Entry { return p.Entries(p.DataDir()) }
+
+// MetadataEntries returns all the entries of the package metadata directory.
+func (p *Package) MetadataEntries() []Entry { return p.Entries(paths.MetadataDir) }
+
+// Open opens the entry for reading. The contents of metadata files are decoded.
+func (p *Package) Open(e Entry) (io.ReadCloser, error) {
+	if e.file == nil {
+		return nil, fmt.Errorf("%w: `%s`", ErrEntryNotFound, e.ArchivePath())
+	}
+
+	r, err := e.file.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Dir == paths.MetadataDir {
+		return struct {
+			io.Reader
+			io.Closer
+		}{DecodeMetadataFile(r), r}, nil
+	}
+	return r, nil
+}
+
+// ReadMetadata reads and decodes the metadata file with the provided name.
+func (p *Package) ReadMetadata(name string) ([]byte, error) {
+	for _, e := range p.MetadataEntries() {
+		if e.Path != name {
+			continue
+		}
+
+		r, err := p.Open(e)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return io.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("%w: `%s`", ErrEntryNotFound, paths.MetadataDir+"/"+name)
+}
+
+// WriteWithMetadataFile writes a copy of the package with the metadata file `name` set to `contents`.
+// An existing metadata file with the same name is replaced.
+func (p *Package) WriteWithMetadataFile(w io.Writer, name string, contents []byte) error {
+	ar := archiver.NewArchiver(w)
+	defer ar.Close()
+
+	ar.Comment(p.comment)
+
+	// Copy all the entries as-is, without recompressing them.
+	for _, e := range p.entries {
+		if e.Dir == paths.MetadataDir && e.Path == name {
+			continue
+		}
+		if err := ar.Writer().Copy(e.file); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := encodeMetadataFile(contents, false)
+	if err != nil {
+		return err
+	}
+
+	metadataW, err := ar.FileBuilder(paths.MetadataDir + "/" + name).
+		Comment("RaftPM package metadata").
+		Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = metadataW.Write(encoded)
+	return err
+}
@@ -8,4 +8,8 @@ var (
 	CopyDataDir           = "cpdata"
 	IntegrationScriptsDir = "iscripts"
 	ManifestFile          = path.Join(MetadataDir, "manifest.json")
+	CompiledManifestName  = "manifest"
+	CompiledManifestFile  = path.Join(MetadataDir, CompiledManifestName)
+	SignatureName         = "signature"
+	HashesName            = "hashes"
 )
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
@@ -25,6 +26,9 @@ var (
 	ErrCouldNotValidateTemplate     = errors.New("couldn't validate the template")
 	ErrRequiredFileIsDir            = errors.New("required file is a directory")
 	ErrRequiredDirIsFile            = errors.New("required directory is a file")
+	ErrRaftpmTooOld                 = errors.New("package requires a newer version of raftpm")
+	ErrReservedMetadataFile         = errors.New("metadata file name is reserved")
+	ErrUnknownShellExeReferenced    = errors.New("unknown shell executable was referenced")
 )
 
 var (
@@ -32,79 +36,87 @@ var (
 	AllowedArchOs  = []string{"bsd", "linux", "macos"}
 )
 
+// selfPackageDocs lists the documentation files bundled into the self-package, if they're present
+// next to the running executable.
+var selfPackageDocs = []string{"LICENSE", "README.md", "CODE_OF_CONDUCT.md"}
+
 // GenerateSelfPackage makes a package from the currently running raftpm instance itself.
 func GenerateSelfPackage(w io.Writer) error {
 	if err := global.Init(); err != nil {
 		return err
 	}
 
+	selfManifest, selfInfo, err := SelfPackageManifest()
+	if err != nil {
+		return err
+	}
+	cpNames, cpFiles, err := SelfPackageFiles()
+	if err != nil {
+		return err
+	}
+
 	// Create the archive.
 	ar := archiver.NewArchiver(w)
 	defer ar.Close()
 
 	// Create the directories.
-	ar.CreateDir("cpdata")
-	ar.CreateDir("metadata")
+	ar.CreateDir(paths.CopyDataDir)
+	ar.CreateDir(paths.MetadataDir)
 
 	// Read the cpData files, and add them to the archive.
-	cpFiles := []string{"CODE_OF_CONDUCT.md", "raftpm"}
-	for _, name := range cpFiles {
-		builder := ar.FileBuilder(path.Join("cpdata", name))
-		file, err := os.Open(path.Join(global.Global.RunningExecutableDir(), name))
-		if err != nil {
-			return err
-		}
+	hashes := Hashes{}
+	for _, name := range cpNames {
+		filePath := cpFiles[name]
 
-		// Apply the mode.
-		if stat, err := file.Stat(); err != nil {
+		fileBuf, err := os.ReadFile(filePath)
+		if err != nil {
 			return err
-		} else {
-			builder.Mode(stat.Mode())
 		}
-
-		// Build the file.
-		w, err := builder.Build()
+		stat, err := os.Stat(filePath)
 		if err != nil {
 			return err
 		}
 
-		// Read the file, and add it to the archive.
-		fileBuf, err := io.ReadAll(file)
+		// Build the file, applying the mode.
+		zipFilePath := path.Join(paths.CopyDataDir, name)
+		fileW, err := ar.FileBuilder(zipFilePath).Mode(stat.Mode()).Build()
 		if err != nil {
 			return err
 		}
-		if _, err := w.Write(fileBuf); err != nil {
+		if _, err := fileW.Write(fileBuf); err != nil {
 			return err
 		}
+
+		hashes[zipFilePath] = NewFileHash(fileBuf, stat.Mode())
+	}
+
+	// Add the hash list.
+	compiledHashes, err := encodeHashes(hashes)
+	if err != nil {
+		return err
+	}
+
+	hashesW, err := ar.FileBuilder(path.Join(paths.MetadataDir, paths.HashesName)).Build()
+	if err != nil {
+		return err
+	}
+
+	if _, err := hashesW.Write(compiledHashes); err != nil {
+		return err
 	}
 
 	// Add the manifest.
-	rawManifest := `{
-		"raftpmVersion": "0.0.0",
-		"name": "raftpm",
-		"version": "0.1.0",
-		"type": "binPkg",
-		"arch": {
-			"cpu": ["x86_64"],
-			"os": ["linux"]
-		},
-		"about": {
-			"description": "A sample hello world program"
-		},
-		"binRegistry": {
-			"raftpm": "local:raftpm"
-		},
-		"binShellExe": {
-			"raftpm": "raftpm"
-		}
-	}`
+	rawManifest, err := manifest.MarshalManifest(selfManifest, selfInfo)
+	if err != nil {
+		return err
+	}
 
-	compiledManifest, err := encodeMetadataFile([]byte(rawManifest), true)
+	compiledManifest, err := encodeMetadataFile(rawManifest, true)
 	if err != nil {
 		return err
 	}
 
-	manifestW, err := ar.FileBuilder("metadata/manifest").Build()
+	manifestW, err := ar.FileBuilder(paths.CompiledManifestFile).Build()
 	if err != nil {
 		return err
 	}
@@ -116,6 +128,54 @@ func GenerateSelfPackage(w io.Writer) error {
 	return nil
 }
 
+// SelfPackageFiles returns the names of the cpData files of the self-package, in the archive order,
+// along with the paths they're read from. The executable is required, the documentation is optional.
+func SelfPackageFiles() ([]string, map[string]string, error) {
+	cpNames := []string{"raftpm"}
+	cpFiles := map[string]string{"raftpm": global.Global.RunningExecutablePath()}
+	for _, name := range selfPackageDocs {
+		filePath := path.Join(global.Global.RunningExecutableDir(), name)
+		if stat, err := os.Stat(filePath); err == nil && stat.Mode().IsRegular() {
+			cpNames = append(cpNames, name)
+			cpFiles[name] = filePath
+		} else if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+	return cpNames, cpFiles, nil
+}
+
+// SelfPackageManifest describes the running raftpm instance as a binary package.
+func SelfPackageManifest() (manifest.BinaryPkg, manifest.PkgCommonInfo, error) {
+	cpu, hostOs, err := HostArch()
+	if err != nil {
+		return manifest.BinaryPkg{}, manifest.PkgCommonInfo{}, err
+	}
+
+	selfManifest := manifest.BinaryPkg{
+		Name: "raftpm",
+		Arch: map[string][]string{
+			ArchKeyCpu: {cpu},
+			ArchKeyOs:  {hostOs},
+		},
+		About: map[string]string{
+			"description": "Portable package manager, which doesn't require superuser privileges",
+		},
+		BinRegistry: map[string]common.PkgPath{
+			"raftpm": {Type: common.PkgPathTypeLocal, Path: "raftpm"},
+		},
+		BinShellExe: map[string]string{
+			"raftpm": "raftpm",
+		},
+	}
+	selfInfo := manifest.PkgCommonInfo{
+		PkgVersion:    global.Version(),
+		RaftpmVersion: global.Version(),
+	}
+
+	return selfManifest, selfInfo, nil
+}
+
 // CompileTemplate validates and compiles the template.
 func CompileTemplate(templatePath string, w io.Writer) error {
 	// Parse the manifest file.
@@ -136,6 +196,10 @@ func CompileTemplate(templatePath string, w io.Writer) error {
 		return fmt.Errorf("couldn't parse manifest: %w", err)
 	}
 
+	if err := CheckRaftpmVersion(pkgCommonInfo); err != nil {
+		return err
+	}
+
 	// Validate the template according to it's type.
 	switch pkgManifest := pkgManifest.(type) {
 	case manifest.BinaryPkg:
@@ -157,6 +221,9 @@ func CompileTemplate(templatePath string, w io.Writer) error {
 	// Add a comment.
 	ar.Comment("Package generated by the raft package manager")
 
+	// Hashes of all the data files, recorded as a metadata file.
+	hashes := Hashes{}
+
 	if err := filepath.WalkDir(templatePath, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -207,6 +274,10 @@ func CompileTemplate(templatePath string, w io.Writer) error {
 		// Check if metadata.
 		isMetadata := relativeRootDir == "metadata"
 
+		if relativeRootDir == paths.CopyDataDir || relativeRootDir == paths.IntegrationScriptsDir {
+			hashes[zipFilePath] = NewFileHash(fileBuf, fileInfo.Mode())
+		}
+
 		// Special treatment for the manifest file.
 		if isMetadata {
 			isJson := filepath.Ext(relativePath) == "json"
@@ -215,6 +286,12 @@ func CompileTemplate(templatePath string, w io.Writer) error {
 				return err
 			}
 			zipFilePath = relativePath[:len(relativePath)-len(filepath.Ext(relativePath))]
+
+			// Some of the metadata files are generated.
+			if zipFilePath == path.Join(paths.MetadataDir, paths.HashesName) ||
+				zipFilePath == path.Join(paths.MetadataDir, paths.SignatureName) {
+				return fmt.Errorf("%w: `%s`", ErrReservedMetadataFile, relativePath)
+			}
 		}
 
 		// Add the file to the archive.
@@ -239,6 +316,32 @@ func CompileTemplate(templatePath string, w io.Writer) error {
 		return err
 	}
 
+	// Add the hash list.
+	compiledHashes, err := encodeHashes(hashes)
+	if err != nil {
+		return err
+	}
+
+	hashesW, err := ar.FileBuilder(path.Join(paths.MetadataDir, paths.HashesName)).
+		Comment("RaftPM package metadata").
+		Build()
+	if err != nil {
+		return err
+	}
+
+	if _, err := hashesW.Write(compiledHashes); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CheckRaftpmVersion returns an error if the package requires a newer raftpm, than the running one.
+func CheckRaftpmVersion(pkgCommonInfo manifest.PkgCommonInfo) error {
+	if pkgCommonInfo.RaftpmVersion.GT(global.Version()) {
+		return fmt.Errorf("%w: version %s or newer is required, but this is %s",
+			ErrRaftpmTooOld, pkgCommonInfo.RaftpmVersion, global.Version())
+	}
 	return nil
 }
 
@@ -315,6 +418,15 @@ func validateBinaryPkgTemplate(templatePath string, binPkgManifest manifest.Bina
 		v.RequireFile(path.Join(templatePath, paths.CopyDataDir, p.Path))
 	}
 
+	if err := validateArch(binPkgManifest.Arch); err != nil {
+		return err
+	}
+
+	// Verify that all the dependency ranges are valid.
+	if _, err := binPkgManifest.DependencyRanges(); err != nil {
+		return err
+	}
+
 	// Verify that only registered binaries are referenced.
 	for _, bin := range binPkgManifest.BinShellExe {
 		if _, ok := binPkgManifest.BinRegistry[bin]; !ok {
@@ -322,6 +434,17 @@ func validateBinaryPkgTemplate(templatePath string, binPkgManifest manifest.Bina
 		}
 	}
 
+	// Verify that the capabilities only reference existing shell executables.
+	for _, c := range binPkgManifest.Capabilities {
+		if _, ok := binPkgManifest.BinShellExe[c.Exe]; !ok {
+			return fmt.Errorf("%w: `%s` (capability `%s`)", ErrUnknownShellExeReferenced, c.Exe, c.Capability)
+		}
+	}
+
+	if binPkgManifest.Icon != nil && binPkgManifest.Icon.Type == common.PkgPathTypeLocal {
+		v.RequireFile(path.Join(templatePath, paths.CopyDataDir, binPkgManifest.Icon.Path))
+	}
+
 	return v.Validate()
 }
 
@@ -378,5 +501,21 @@ func encodeMetadataFile(metadataFile []byte, isJson bool) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// decodeMetadataFile decodes the provided file, and returns a reader.
-func decodeMetadataFile(r io.Reader) io.Reader { return base64.NewDecoder(base64.StdEncoding, r) }
+// DecodeMetadataFile decodes the provided file, and returns a reader.
+func DecodeMetadataFile(r io.Reader) io.Reader { return base64.NewDecoder(base64.StdEncoding, r) }
+
+// ReadPackageManifest reads and parses the manifest of a compiled package.
+func ReadPackageManifest(zr *zip.Reader, outPkgCommonInfo *manifest.PkgCommonInfo) (interface{}, error) {
+	manifestFile, err := zr.Open(paths.CompiledManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open the manifest: %w", err)
+	}
+	defer manifestFile.Close()
+
+	rawManifest, err := io.ReadAll(DecodeMetadataFile(manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode the manifest: %w", err)
+	}
+
+	return manifest.ParseManifest(rawManifest, outPkgCommonInfo)
+}
@@ -0,0 +1,273 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+)
+
+var (
+	ErrDependencyCycle         = errors.New("dependency cycle")
+	ErrUnsatisfiableDependency = errors.New("unsatisfiable dependency")
+	ErrConflictingDependency   = errors.New("conflicting dependency requirements")
+	ErrInvalidDependencyRange  = errors.New("invalid dependency range")
+)
+
+// maxPasses limits the number of times the resolution is restarted after discovering new constraints.
+const maxPasses = 64
+
+// Candidate is a package version, which could be a part of the install set.
+type Candidate struct {
+	Name    string
+	Version semver.Version
+	// Dependencies maps names of the required packages to semver ranges.
+	Dependencies map[string]string
+	// Installed marks the candidates already present in the workspace.
+	Installed bool
+}
+
+func (c Candidate) String() string { return c.Name + " " + c.Version.String() }
+
+// constraint is a version range imposed on a package by one of the candidates.
+type constraint struct {
+	rawRange string
+	r        semver.Range
+	origin   Candidate
+}
+
+func (c constraint) String() string {
+	return fmt.Sprintf("`%s` (required by %s)", c.rawRange, c.origin)
+}
+
+type resolver struct {
+	requested   map[string]Candidate
+	available   map[string][]Candidate
+	constraints map[string][]constraint
+	selected    map[string]Candidate
+	order       []Candidate
+	path        []Candidate
+}
+
+// Resolve computes the set of candidates, which have to be installed for the requested ones to work.
+// Dependencies are satisfied by installed candidates when possible, otherwise the highest matching
+// version is picked. The result is ordered so that dependencies come before their dependents,
+// and doesn't include the already installed candidates.
+func Resolve(requested []Candidate, available []Candidate) ([]Candidate, error) {
+	r := resolver{
+		requested:   make(map[string]Candidate),
+		available:   make(map[string][]Candidate),
+		constraints: make(map[string][]constraint),
+	}
+
+	for _, c := range requested {
+		if other, ok := r.requested[c.Name]; ok && !other.Version.EQ(c.Version) {
+			return nil, fmt.Errorf("%w: both %s and %s were requested", ErrConflictingDependency, other, c)
+		}
+		r.requested[c.Name] = c
+	}
+
+	for _, c := range append(append([]Candidate{}, requested...), available...) {
+		if !r.isAvailable(c) {
+			r.available[c.Name] = append(r.available[c.Name], c)
+		}
+	}
+
+	// Prefer installed candidates, then higher versions.
+	for _, candidates := range r.available {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].Installed != candidates[j].Installed {
+				return candidates[i].Installed
+			}
+			return candidates[i].Version.GT(candidates[j].Version)
+		})
+	}
+
+	// Every pass may discover new constraints, which invalidate earlier choices.
+	// In that case the resolution is restarted, taking the new constraints into account.
+	for pass := 0; pass < maxPasses; pass++ {
+		r.selected = make(map[string]Candidate)
+		r.order = nil
+
+		restart, err := r.resolveAll(requested)
+		if err != nil {
+			return nil, err
+		}
+		if restart {
+			continue
+		}
+
+		var result []Candidate
+		for _, c := range r.order {
+			if !c.Installed {
+				result = append(result, c)
+			}
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("%w: couldn't settle on a set of versions", ErrConflictingDependency)
+}
+
+func (r *resolver) isAvailable(c Candidate) bool {
+	for _, other := range r.available[c.Name] {
+		if other.Version.EQ(c.Version) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAll selects the requested candidates and their dependencies.
+// Returns true, if the resolution has to be restarted.
+func (r *resolver) resolveAll(requested []Candidate) (bool, error) {
+	for _, c := range requested {
+		restart, err := r.visit(c)
+		if err != nil || restart {
+			return restart, err
+		}
+	}
+	return false, nil
+}
+
+// visit selects the candidate, and recursively resolves all of it's dependencies.
+// Returns true, if the resolution has to be restarted.
+func (r *resolver) visit(c Candidate) (bool, error) {
+	// Detect cycles.
+	for i, onPath := range r.path {
+		if onPath.Name == c.Name {
+			cycle := append(append([]Candidate{}, r.path[i:]...), c)
+			names := make([]string, 0, len(cycle))
+			for _, n := range cycle {
+				names = append(names, n.String())
+			}
+			return false, fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(names, " -> "))
+		}
+	}
+
+	if _, ok := r.selected[c.Name]; ok {
+		return false, nil
+	}
+	r.selected[c.Name] = c
+
+	r.path = append(r.path, c)
+	defer func() { r.path = r.path[:len(r.path)-1] }()
+
+	// Walk the dependencies in a stable order, so that the errors are reproducible.
+	names := make([]string, 0, len(c.Dependencies))
+	for name := range c.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		restart, err := r.addConstraint(name, c)
+		if err != nil || restart {
+			return restart, err
+		}
+
+		dep, err := r.pick(name)
+		if err != nil {
+			return false, err
+		}
+
+		restart, err = r.visit(dep)
+		if err != nil || restart {
+			return restart, err
+		}
+	}
+
+	r.order = append(r.order, c)
+	return false, nil
+}
+
+// addConstraint records the constraint that `origin` imposes on the package `name`.
+// Returns true if the constraint is new, and is violated by the already selected candidate.
+func (r *resolver) addConstraint(name string, origin Candidate) (bool, error) {
+	rawRange := origin.Dependencies[name]
+	for _, known := range r.constraints[name] {
+		if known.origin.Name == origin.Name && known.origin.Version.EQ(origin.Version) {
+			return false, nil
+		}
+	}
+
+	versionRange, err := manifest.ParseDependencyRange(rawRange)
+	if err != nil {
+		return false, fmt.Errorf("%w: `%s` required by %s: %w", ErrInvalidDependencyRange, rawRange, origin, err)
+	}
+
+	known := constraint{rawRange, versionRange, origin}
+	r.constraints[name] = append(r.constraints[name], known)
+
+	// Explicitly requested versions can't be replaced by other ones.
+	if requested, ok := r.requested[name]; ok && !versionRange(requested.Version) {
+		return false, fmt.Errorf("%w: %s was requested, but %s", ErrConflictingDependency, requested, known)
+	}
+
+	selected, ok := r.selected[name]
+	return ok && !versionRange(selected.Version), nil
+}
+
+// pick returns the best available candidate satisfying all the known constraints on the package.
+func (r *resolver) pick(name string) (Candidate, error) {
+	if selected, ok := r.selected[name]; ok && r.satisfies(selected) {
+		return selected, nil
+	}
+	if requested, ok := r.requested[name]; ok {
+		return requested, nil
+	}
+
+	for _, c := range r.available[name] {
+		if r.satisfies(c) {
+			return c, nil
+		}
+	}
+
+	return Candidate{}, r.explain(name)
+}
+
+func (r *resolver) satisfies(c Candidate) bool {
+	for _, known := range r.constraints[c.Name] {
+		if !known.r(c.Version) {
+			return false
+		}
+	}
+	return true
+}
+
+// explain builds a readable error, describing why no candidate could be picked for the package.
+func (r *resolver) explain(name string) error {
+	constraints := r.constraints[name]
+
+	versions := make([]string, 0, len(r.available[name]))
+	for _, c := range r.available[name] {
+		versions = append(versions, c.Version.String())
+	}
+	availableDesc := "no versions are available"
+	if len(versions) != 0 {
+		availableDesc = "available versions: " + strings.Join(versions, ", ")
+	}
+
+	// Check if any single constraint is unsatisfiable on it's own.
+	for _, known := range constraints {
+		satisfiable := false
+		for _, c := range r.available[name] {
+			if known.r(c.Version) {
+				satisfiable = true
+				break
+			}
+		}
+		if !satisfiable {
+			return fmt.Errorf("%w: `%s` %s; %s", ErrUnsatisfiableDependency, name, known, availableDesc)
+		}
+	}
+
+	descs := make([]string, 0, len(constraints))
+	for _, known := range constraints {
+		descs = append(descs, known.String())
+	}
+	return fmt.Errorf("%w: `%s`: %s; %s", ErrConflictingDependency, name, strings.Join(descs, ", "), availableDesc)
+}
@@ -0,0 +1,127 @@
+package resolver
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver/v4"
+)
+
+func candidate(name string, version string, dependencies map[string]string) Candidate {
+	return Candidate{Name: name, Version: semver.MustParse(version), Dependencies: dependencies}
+}
+
+func installed(name string, version string, dependencies map[string]string) Candidate {
+	c := candidate(name, version, dependencies)
+	c.Installed = true
+	return c
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested []Candidate
+		available []Candidate
+		want      []string
+		wantErr   error
+	}{
+		{
+			name:      "no dependencies",
+			requested: []Candidate{candidate("app", "1.0.0", nil)},
+			want:      []string{"app 1.0.0"},
+		},
+		{
+			name:      "dependencies come first",
+			requested: []Candidate{candidate("app", "1.0.0", map[string]string{"lib": ">=1.0.0"})},
+			available: []Candidate{candidate("lib", "1.0.0", nil), candidate("lib", "1.2.0", nil)},
+			want:      []string{"lib 1.2.0", "app 1.0.0"},
+		},
+		{
+			name:      "installed dependency is preferred",
+			requested: []Candidate{candidate("app", "1.0.0", map[string]string{"lib": ">=1.0.0"})},
+			available: []Candidate{installed("lib", "1.0.0", nil), candidate("lib", "1.2.0", nil)},
+			want:      []string{"app 1.0.0"},
+		},
+		{
+			name: "later constraint replaces the earlier choice",
+			requested: []Candidate{
+				candidate("app", "1.0.0", map[string]string{"a": "*", "lib": ">=1.0.0"}),
+			},
+			available: []Candidate{
+				candidate("a", "1.0.0", map[string]string{"lib": "<1.2.0"}),
+				candidate("lib", "1.0.0", nil),
+				candidate("lib", "1.2.0", nil),
+			},
+			want: []string{"lib 1.0.0", "a 1.0.0", "app 1.0.0"},
+		},
+		{
+			name:      "unsatisfiable",
+			requested: []Candidate{candidate("app", "1.0.0", map[string]string{"lib": ">=2.0.0"})},
+			available: []Candidate{candidate("lib", "1.0.0", nil)},
+			wantErr:   ErrUnsatisfiableDependency,
+		},
+		{
+			name:      "missing dependency",
+			requested: []Candidate{candidate("app", "1.0.0", map[string]string{"lib": "*"})},
+			wantErr:   ErrUnsatisfiableDependency,
+		},
+		{
+			name: "conflicting constraints",
+			requested: []Candidate{
+				candidate("a", "1.0.0", map[string]string{"lib": "<1.2.0"}),
+				candidate("b", "1.0.0", map[string]string{"lib": ">=1.2.0"}),
+			},
+			available: []Candidate{candidate("lib", "1.0.0", nil), candidate("lib", "1.2.0", nil)},
+			wantErr:   ErrConflictingDependency,
+		},
+		{
+			name:      "conflicting requests",
+			requested: []Candidate{candidate("lib", "1.0.0", nil), candidate("lib", "1.2.0", nil)},
+			wantErr:   ErrConflictingDependency,
+		},
+		{
+			name: "requested version violates a constraint",
+			requested: []Candidate{
+				candidate("app", "1.0.0", map[string]string{"lib": ">=1.2.0"}),
+				candidate("lib", "1.0.0", nil),
+			},
+			wantErr: ErrConflictingDependency,
+		},
+		{
+			name:      "cycle",
+			requested: []Candidate{candidate("a", "1.0.0", map[string]string{"b": "*"})},
+			available: []Candidate{candidate("b", "1.0.0", map[string]string{"a": "*"})},
+			wantErr:   ErrDependencyCycle,
+		},
+		{
+			name:      "invalid range",
+			requested: []Candidate{candidate("app", "1.0.0", map[string]string{"lib": "not a range"})},
+			available: []Candidate{candidate("lib", "1.0.0", nil)},
+			wantErr:   ErrInvalidDependencyRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := Resolve(tt.requested, tt.available)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := make([]string, 0, len(resolved))
+			for _, c := range resolved {
+				got = append(got, c.String())
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
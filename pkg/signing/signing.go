@@ -0,0 +1,195 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/paths"
+)
+
+var (
+	ErrUnsigned         = errors.New("package is not signed")
+	ErrUntrustedKey     = errors.New("package is signed with an untrusted key")
+	ErrBadSignature     = errors.New("package signature is invalid")
+	ErrMalformedKey     = errors.New("malformed key")
+	ErrMalformedSigFile = errors.New("malformed signature")
+)
+
+// Signature is an ed25519 signature of a compiled package.
+// It's either embedded into the package metadata, or stored in a detached file.
+type Signature struct {
+	KeyID     string `json:"keyId"`
+	Signature []byte `json:"signature"`
+}
+
+// Keyring looks up the trusted public keys by their ids.
+type Keyring interface {
+	Lookup(keyID string) (ed25519.PublicKey, bool)
+}
+
+// GenerateKey generates a new key pair.
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// KeyID returns the short identifier of the public key.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// EncodePublicKey encodes the public key in the format of key files.
+func EncodePublicKey(pub ed25519.PublicKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(pub) + "\n")
+}
+
+// EncodePrivateKey encodes the private key in the format of key files. Only the seed is stored.
+func EncodePrivateKey(priv ed25519.PrivateKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(priv.Seed()) + "\n")
+}
+
+// ParsePublicKey parses the contents of a public key file.
+func ParsePublicKey(raw []byte) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%w: expected a base64-encoded ed25519 public key", ErrMalformedKey)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ParsePrivateKey parses the contents of a private key file.
+func ParsePrivateKey(raw []byte) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%w: expected a base64-encoded ed25519 seed", ErrMalformedKey)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParseSignature parses an encoded signature.
+func ParseSignature(raw []byte) (Signature, error) {
+	sig := Signature{}
+	if err := json.Unmarshal(raw, &sig); err != nil {
+		return sig, fmt.Errorf("%w: %w", ErrMalformedSigFile, err)
+	}
+	if sig.KeyID == "" || len(sig.Signature) != ed25519.SignatureSize {
+		return sig, ErrMalformedSigFile
+	}
+	return sig, nil
+}
+
+// Encode encodes the signature, so that it could be parsed by ParseSignature.
+func (s Signature) Encode() ([]byte, error) { return json.Marshal(s) }
+
+// Digest computes the digest of the package, covering the path, mode and contents of every entry.
+// The embedded signature isn't covered, so that it could be added after the digest is computed.
+func Digest(compiled *pkg.Package) ([]byte, error) {
+	var entries []pkg.Entry
+	for _, dir := range []string{paths.CopyDataDir, paths.IntegrationScriptsDir, paths.MetadataDir} {
+		for _, e := range compiled.Entries(dir) {
+			if isSignatureEntry(e) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ArchivePath() < entries[j].ArchivePath() })
+
+	digest := sha256.New()
+	for _, e := range entries {
+		contentSum := sha256.New()
+		if !e.IsDir() {
+			r, err := compiled.Open(e)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(contentSum, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		fmt.Fprintf(digest, "%s\x00%s\x00%x\n", e.ArchivePath(), e.Mode, contentSum.Sum(nil))
+	}
+
+	return digest.Sum(nil), nil
+}
+
+// Sign produces the signature of the package.
+func Sign(compiled *pkg.Package, priv ed25519.PrivateKey) (Signature, error) {
+	digest, err := Digest(compiled)
+	if err != nil {
+		return Signature{}, err
+	}
+
+	return Signature{
+		KeyID:     KeyID(priv.Public().(ed25519.PublicKey)),
+		Signature: ed25519.Sign(priv, digest),
+	}, nil
+}
+
+// EmbeddedSignature returns the signature embedded into the package metadata.
+// If the package has no embedded signature, ErrUnsigned is returned.
+func EmbeddedSignature(compiled *pkg.Package) (Signature, error) {
+	raw, err := compiled.ReadMetadata(paths.SignatureName)
+	if errors.Is(err, pkg.ErrEntryNotFound) {
+		return Signature{}, ErrUnsigned
+	} else if err != nil {
+		return Signature{}, err
+	}
+	return ParseSignature(raw)
+}
+
+// Embed writes a copy of the package with the signature embedded into it's metadata.
+// An already embedded signature is replaced.
+func Embed(compiled *pkg.Package, sig Signature, w io.Writer) error {
+	rawSig, err := sig.Encode()
+	if err != nil {
+		return err
+	}
+
+	return compiled.WriteWithMetadataFile(w, paths.SignatureName, rawSig)
+}
+
+// Verify verifies the signature of the package against the trusted keys.
+// If sig is nil, the embedded signature is used.
+func Verify(compiled *pkg.Package, sig *Signature, keyring Keyring) (Signature, error) {
+	if sig == nil {
+		embedded, err := EmbeddedSignature(compiled)
+		if err != nil {
+			return embedded, err
+		}
+		sig = &embedded
+	}
+
+	pub, ok := keyring.Lookup(sig.KeyID)
+	if !ok {
+		return *sig, fmt.Errorf("%w: `%s`", ErrUntrustedKey, sig.KeyID)
+	}
+
+	digest, err := Digest(compiled)
+	if err != nil {
+		return *sig, err
+	}
+
+	if !ed25519.Verify(pub, digest, sig.Signature) {
+		return *sig, fmt.Errorf("%w: key `%s`", ErrBadSignature, sig.KeyID)
+	}
+
+	return *sig, nil
+}
+
+func isSignatureEntry(e pkg.Entry) bool {
+	return e.Dir == paths.MetadataDir && e.Path == paths.SignatureName
+}
@@ -1,7 +1,71 @@
 package files
 
-import "io/fs"
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
 
 func IsUnixExecutableFile(fileInfo fs.FileInfo) bool {
 	return fileInfo.Mode()&0100 != 0 || fileInfo.Mode()&0010 != 0 || fileInfo.Mode()&0001 != 0
 }
+
+// WriteFileAtomic writes the data to a temporary file next to the destination, and renames it into place,
+// so that the destination is never left partially written. Missing parent directories are created.
+func WriteFileAtomic(p string, data []byte, perm fs.FileMode) error {
+	return WriteReaderAtomic(p, bytes.NewReader(data), perm)
+}
+
+// WriteReaderAtomic is WriteFileAtomic for the data read from r.
+func WriteReaderAtomic(p string, r io.Reader, perm fs.FileMode) error {
+	if err := os.MkdirAll(path.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(path.Dir(p), "."+path.Base(p)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+// DirSize returns the total size of the regular files in the directory tree.
+// A missing directory has no size.
+func DirSize(p string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return size, err
+}
@@ -0,0 +1,77 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+var (
+	ErrLinksUnsupported = errors.New("filesystem supports neither hardlinks nor reflinks")
+)
+
+// Link makes dst share the contents of src without copying them.
+// A hardlink is tried first, then a reflink (a copy-on-write clone) where the platform supports them.
+// If neither works, ErrLinksUnsupported is returned, and the caller is expected to copy the file.
+func Link(src string, dst string) error {
+	linkErr := os.Link(src, dst)
+	if linkErr == nil {
+		return nil
+	}
+	if errors.Is(linkErr, fs.ErrExist) {
+		return linkErr
+	}
+
+	if err := reflink(src, dst); err == nil {
+		return nil
+	}
+
+	return ErrLinksUnsupported
+}
+
+// LinkOrCopy links dst to src, copying the file if the filesystem doesn't support links.
+func LinkOrCopy(src string, dst string) error {
+	err := Link(src, dst)
+	if !errors.Is(err, ErrLinksUnsupported) {
+		return err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	return CopyFile(src, dst, info.Mode(), info.ModTime())
+}
+
+// LinkTree recreates the directory tree of src in dst, linking the files.
+// Files are copied instead, if the filesystem doesn't support links.
+func LinkTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		dstPath := path.Join(dst, filepath.ToSlash(relativePath))
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(dstPath, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return LinkOrCopy(p, dstPath)
+		default:
+			return fmt.Errorf("%w: `%s`", ErrUnsupportedFileType, p)
+		}
+	})
+}
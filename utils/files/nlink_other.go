@@ -0,0 +1,8 @@
+//go:build !unix
+
+package files
+
+import "io/fs"
+
+// LinkCount isn't supported on this platform, the count is always unknown.
+func LinkCount(info fs.FileInfo) (uint64, bool) { return 0, false }
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package files
+
+import "errors"
+
+// reflink isn't supported on this platform.
+func reflink(src string, dst string) error {
+	return errors.New("reflinks are not supported on this platform")
+}
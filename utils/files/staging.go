@@ -0,0 +1,357 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	stagingDirName = ".raftpm-staging"
+	backupDirName  = ".raftpm-backup"
+	// journalName is the file inside the staging directory, which marks a commit in progress.
+	journalName = ".commit"
+)
+
+// Staging is a directory inside the destination, where the replacements of some of it's entries are built.
+// The destination is only changed by Commit, which swaps the staged entries into place.
+// Everything else in the destination is left alone.
+//
+// The staged entries may be populated with the destination contents by Copy and Link.
+// Files are linked when possible, so modifications must replace the files,
+// instead of writing to them, otherwise the destination changes too.
+type Staging struct {
+	Path    string
+	dst     string
+	entries []string
+	// created is set, if the destination didn't exist before the staging.
+	created bool
+	// carried maps the destination files moved into the staging directory by Commit,
+	// to whether they replace the staged file of the same path.
+	carried map[string]bool
+}
+
+// stagingJournal is written before Commit changes the destination, so that an interrupted commit can be finished.
+type stagingJournal struct {
+	// Entries maps the staged entries to whether they exist after the commit.
+	Entries map[string]bool `json:"entries"`
+	Carried map[string]bool `json:"carried"`
+}
+
+// NewStaging creates an empty staging directory for the provided entries of the destination.
+// The entries are the names of the files and directories directly inside the destination.
+//
+// An interrupted commit of an earlier staging is finished first, other leftovers are removed.
+func NewStaging(dst string, entries []string) (*Staging, error) {
+	dst = filepath.Clean(dst)
+	s := Staging{
+		Path:    path.Join(dst, stagingDirName),
+		dst:     dst,
+		entries: entries,
+		carried: make(map[string]bool),
+	}
+
+	if _, err := os.Stat(dst); os.IsNotExist(err) {
+		s.created = true
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := s.recover(); err != nil {
+		return nil, fmt.Errorf("couldn't recover from an interrupted staging: %w", err)
+	}
+	if err := os.MkdirAll(s.Path, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Copy populates the staged entry with the contents of the destination one.
+// Files are copied, where they can't be linked.
+func (s *Staging) Copy(entry string) error {
+	return s.populate(entry, false)
+}
+
+// Link populates the staged entry with links to the contents of the destination one.
+// Files, which can't be linked, aren't copied. Commit moves them into the staging directory instead,
+// unless the staged file was written in the meantime, so they can't be read before the commit.
+func (s *Staging) Link(entry string) error {
+	return s.populate(entry, true)
+}
+
+// Carry makes Commit move the destination file into the staging directory, replacing the staged one.
+// It's cheaper than copying a file, which is known to be unchanged. The path is relative to the destination.
+func (s *Staging) Carry(p string) { s.carried[path.Clean(p)] = true }
+
+// Drop cancels moving the destination file into the staging directory, so that it's left out of the commit.
+func (s *Staging) Drop(p string) { delete(s.carried, path.Clean(p)) }
+
+// populate recreates the directory tree of the destination entry in the staging directory.
+// If carry is set, files which can't be linked are carried over by Commit, otherwise they're copied.
+func (s *Staging) populate(entry string, carry bool) error {
+	src := path.Join(s.dst, entry)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !carry {
+		return LinkTree(src, path.Join(s.Path, entry))
+	}
+
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(s.dst, p)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		dstPath := path.Join(s.Path, relativePath)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(dstPath, info.Mode().Perm())
+		case !info.Mode().IsRegular():
+			return fmt.Errorf("%w: `%s`", ErrUnsupportedFileType, p)
+		}
+
+		err = Link(p, dstPath)
+		if errors.Is(err, ErrLinksUnsupported) {
+			s.carried[relativePath] = false
+			return nil
+		}
+		return err
+	})
+}
+
+// Commit swaps the staged entries into place. Staged entries, which don't exist,
+// are removed from the destination. If the commit fails, the destination is restored.
+//
+// The commit is journaled, so if it's interrupted, the next staging of the destination finishes it.
+func (s *Staging) Commit() error {
+	journal := stagingJournal{Entries: make(map[string]bool), Carried: s.carried}
+	for _, entry := range s.entries {
+		if _, err := os.Stat(path.Join(s.Path, entry)); err == nil {
+			journal.Entries[entry] = true
+		} else if os.IsNotExist(err) {
+			journal.Entries[entry] = false
+		} else {
+			return err
+		}
+	}
+
+	raw, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	if err := WriteFileAtomic(path.Join(s.Path, journalName), raw, 0644); err != nil {
+		return err
+	}
+
+	if err := s.apply(journal); err != nil {
+		if undoErr := s.undo(journal); undoErr != nil {
+			return fmt.Errorf("%w; couldn't restore `%s`: %w", err, s.dst, undoErr)
+		}
+		return err
+	}
+
+	return s.cleanup()
+}
+
+// Rollback removes the staging directory, leaving the destination untouched.
+// A destination created by the staging is removed as well, if nothing else was put there.
+func (s *Staging) Rollback() error {
+	if err := os.RemoveAll(s.Path); err != nil {
+		return err
+	}
+	if s.created && !hasEntries(s.dst) {
+		if err := os.Remove(s.dst); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply carries the files over, and swaps the entries. It can be repeated after an interruption.
+func (s *Staging) apply(journal stagingJournal) error {
+	backup := path.Join(s.dst, backupDirName)
+	if err := os.MkdirAll(backup, os.ModePerm); err != nil {
+		return err
+	}
+
+	// Carry the files over, before the staged entries are moved away.
+	for _, p := range sortedKeys(journal.Carried) {
+		srcPath, dstPath := path.Join(s.dst, p), path.Join(s.Path, p)
+
+		// Once the entry is swapped, the destination holds the staged files.
+		entry := strings.SplitN(p, "/", 2)[0]
+		if _, err := os.Stat(path.Join(s.Path, entry)); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		srcInfo, err := os.Stat(srcPath)
+		if os.IsNotExist(err) {
+			// Already carried over.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if dstInfo, err := os.Stat(dstPath); err == nil {
+			if !journal.Carried[p] || os.SameFile(srcInfo, dstInfo) {
+				continue
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := os.MkdirAll(path.Dir(dstPath), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(srcPath, dstPath); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range sortedKeys(journal.Entries) {
+		entryPath, stagedPath, backupPath := path.Join(s.dst, entry), path.Join(s.Path, entry), path.Join(backup, entry)
+
+		// An existing staged entry means it wasn't swapped yet.
+		if journal.Entries[entry] {
+			if _, err := os.Stat(stagedPath); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if _, err := os.Stat(entryPath); err == nil {
+			if err := os.RemoveAll(backupPath); err != nil {
+				return err
+			}
+			if err := os.Rename(entryPath, backupPath); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if journal.Entries[entry] {
+			if err := os.Rename(stagedPath, entryPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// undo reverts a failed apply, moving the original entries and the carried files back.
+func (s *Staging) undo(journal stagingJournal) error {
+	backup := path.Join(s.dst, backupDirName)
+
+	for _, entry := range sortedKeys(journal.Entries) {
+		entryPath, stagedPath, backupPath := path.Join(s.dst, entry), path.Join(s.Path, entry), path.Join(backup, entry)
+
+		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// The entry was moved away, and possibly replaced by the staged one.
+		if journal.Entries[entry] {
+			if _, err := os.Stat(stagedPath); os.IsNotExist(err) {
+				if err := os.Rename(entryPath, stagedPath); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(backupPath, entryPath); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range sortedKeys(journal.Carried) {
+		srcPath, dstPath := path.Join(s.dst, p), path.Join(s.Path, p)
+		if _, err := os.Stat(srcPath); err == nil || !os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(dstPath, srcPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Remove(path.Join(s.Path, journalName))
+}
+
+// cleanup removes the original entries, and the staging directory.
+// The journal goes first, so that the leftovers of an interrupted cleanup are just removed by the recovery.
+func (s *Staging) cleanup() error {
+	if err := os.Remove(path.Join(s.Path, journalName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.RemoveAll(path.Join(s.dst, backupDirName)); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.Path)
+}
+
+// recover finishes a Commit, which was interrupted, and removes the leftovers of a staging, which wasn't committed.
+func (s *Staging) recover() error {
+	raw, err := os.ReadFile(path.Join(s.Path, journalName))
+	if os.IsNotExist(err) {
+		if err := os.RemoveAll(path.Join(s.dst, backupDirName)); err != nil {
+			return err
+		}
+		return os.RemoveAll(s.Path)
+	} else if err != nil {
+		return err
+	}
+
+	var journal stagingJournal
+	if err := json.Unmarshal(raw, &journal); err != nil {
+		return fmt.Errorf("malformed staging journal: %w", err)
+	}
+	if err := s.apply(journal); err != nil {
+		return err
+	}
+	return s.cleanup()
+}
+
+// hasEntries reports whether the directory has any entries.
+func hasEntries(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return err == nil && len(entries) != 0
+}
+
+// sortedKeys returns the keys of the map in order, so that the journaled steps are repeated in the same order.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,201 @@
+package files
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readTree returns the contents of the files under root, keyed by their slash separated relative paths.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(relativePath)] = string(contents)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// replaceTree replaces the files under root, so that the linked destination files stay intact.
+func replaceTree(t *testing.T, root string, tree map[string]string) {
+	t.Helper()
+	for p, contents := range tree {
+		if err := WriteFileAtomic(filepath.Join(root, filepath.FromSlash(p)), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertTree(t *testing.T, root string, want map[string]string) {
+	t.Helper()
+	got := readTree(t, root)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for p, contents := range want {
+		if got[p] != contents {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestStaging(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	original := map[string]string{
+		"raftpm":      "executable",
+		".portable":   "",
+		"config/a":    "old config",
+		"store/b":     "unchanged",
+		"store/c":     "removed",
+		"store/d/e":   "replaced",
+		"cache/stale": "cache",
+	}
+
+	tests := []struct {
+		name  string
+		build func(t *testing.T, s *Staging)
+		// commit is false, if the staging is rolled back.
+		commit bool
+		want   map[string]string
+	}{
+		{
+			name: "commit swaps the staged entries",
+			build: func(t *testing.T, s *Staging) {
+				replaceTree(t, s.Path, map[string]string{"config/a": "new config", "store/d/e": "new"})
+				if err := os.Remove(filepath.Join(s.Path, "store", "c")); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Remove(filepath.Join(s.Path, ".portable")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			commit: true,
+			want: map[string]string{
+				"raftpm":      "executable",
+				"config/a":    "new config",
+				"store/b":     "unchanged",
+				"store/d/e":   "new",
+				"cache/stale": "cache",
+			},
+		},
+		{
+			name: "carried files are moved in by the commit",
+			build: func(t *testing.T, s *Staging) {
+				// Pretend the files couldn't be linked.
+				for _, p := range []string{"b", "c"} {
+					if err := os.Remove(filepath.Join(s.Path, "store", p)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				s.Carry("store/b")
+				s.Carry("store/c")
+				s.Drop("store/c")
+			},
+			commit: true,
+			want: map[string]string{
+				"raftpm":      "executable",
+				".portable":   "",
+				"config/a":    "old config",
+				"store/b":     "unchanged",
+				"store/d/e":   "replaced",
+				"cache/stale": "cache",
+			},
+		},
+		{
+			name: "rollback leaves the destination untouched",
+			build: func(t *testing.T, s *Staging) {
+				replaceTree(t, s.Path, map[string]string{"config/a": "new config", "store/f": "new"})
+			},
+			commit: false,
+			want:   original,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			writeTree(t, dst, original, modTime)
+
+			s, err := NewStaging(dst, []string{"config", ".portable", "store", "cache", "links"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range []string{"config", ".portable"} {
+				if err := s.Copy(entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, entry := range []string{"store", "cache", "links"} {
+				if err := s.Link(entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			tt.build(t, s)
+			if tt.commit {
+				err = s.Commit()
+			} else {
+				err = s.Rollback()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assertTree(t, dst, tt.want)
+		})
+	}
+}
+
+func TestStagingRecovery(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	dst := t.TempDir()
+	writeTree(t, dst, map[string]string{"config/a": "old config", "store/b": "old"}, modTime)
+
+	s, err := NewStaging(dst, []string{"config", "store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceTree(t, s.Path, map[string]string{"config/a": "new config", "store/b": "new"})
+
+	// Interrupt the commit right after the first entry was moved away.
+	raw, err := json.Marshal(stagingJournal{Entries: map[string]bool{"config": true, "store": true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Path, journalName), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dst, backupDirName), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dst, "config"), filepath.Join(dst, backupDirName, "config")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next staging finishes the commit.
+	s, err = NewStaging(dst, []string{"config", "store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	assertTree(t, dst, map[string]string{"config/a": "new config", "store/b": "new"})
+}
@@ -0,0 +1,291 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrUnsupportedFileType = errors.New("unsupported file type")
+)
+
+// modTimeTolerance is the allowed difference between modification times of identical files.
+// FAT filesystems, common on removable drives, store the time with a 2 second resolution.
+const modTimeTolerance = 2 * time.Second
+
+const (
+	SyncMkdir = iota
+	SyncCopy
+	SyncSkip
+	SyncRemove
+)
+
+// SyncOp is a single operation of the sync plan.
+type SyncOp struct {
+	Action int
+	// Path is relative to the synced directories.
+	Path    string
+	Size    int64
+	Mode    fs.FileMode
+	ModTime time.Time
+}
+
+// SyncPlan describes how to make the destination directory a copy of the source one.
+type SyncPlan struct {
+	Src string
+	Dst string
+	Ops []SyncOp
+}
+
+// PlanSync compares the source and the destination directories, planning an incremental sync.
+// Files whose size, modification time and hash match are skipped,
+// files missing from the source are removed from the destination.
+// The excluded paths, relative to both directories, are left out of the sync on both sides.
+func PlanSync(src string, dst string, exclude ...string) (*SyncPlan, error) {
+	plan := SyncPlan{Src: src, Dst: dst}
+	// Maps the paths present in the source to whether they're directories.
+	present := make(map[string]bool)
+	excluded := make(map[string]bool, len(exclude))
+	for _, p := range exclude {
+		excluded[p] = true
+	}
+
+	if err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if relativePath == "." {
+			plan.Ops = append(plan.Ops, SyncOp{Action: SyncMkdir, Path: "."})
+			return nil
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if excluded[relativePath] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		present[relativePath] = d.IsDir()
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		op := SyncOp{Path: relativePath, Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime()}
+		switch {
+		case info.IsDir():
+			op.Action = SyncMkdir
+		case info.Mode().IsRegular():
+			same, err := sameFile(p, path.Join(dst, relativePath), info)
+			if err != nil {
+				return err
+			}
+			if same {
+				op.Action = SyncSkip
+			} else {
+				op.Action = SyncCopy
+			}
+		default:
+			return fmt.Errorf("%w: `%s`", ErrUnsupportedFileType, p)
+		}
+
+		plan.Ops = append(plan.Ops, op)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	// Find everything in the destination, that's missing from the source, or has a different type.
+	if err := filepath.WalkDir(dst, func(p string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && p == dst {
+			return filepath.SkipAll
+		} else if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(dst, p)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+		if relativePath == "." {
+			return nil
+		}
+		if excluded[relativePath] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isDir, ok := present[relativePath]; ok && isDir == d.IsDir() {
+			return nil
+		}
+
+		plan.Ops = append(plan.Ops, SyncOp{Action: SyncRemove, Path: relativePath})
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// BytesToWrite returns the total size of the files, which are going to be copied.
+func (p *SyncPlan) BytesToWrite() int64 {
+	var total int64
+	for _, op := range p.Ops {
+		if op.Action == SyncCopy {
+			total += op.Size
+		}
+	}
+	return total
+}
+
+// Count returns the number of operations with the provided action.
+func (p *SyncPlan) Count(action int) int {
+	count := 0
+	for _, op := range p.Ops {
+		if op.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// Apply executes the plan.
+func (p *SyncPlan) Apply() error {
+	// Removals go first, so that the freed space is available for the copies.
+	for _, op := range p.Ops {
+		if op.Action == SyncRemove {
+			if err := os.RemoveAll(path.Join(p.Dst, op.Path)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, op := range p.Ops {
+		dstPath := path.Join(p.Dst, op.Path)
+		switch op.Action {
+		case SyncMkdir:
+			if err := os.MkdirAll(dstPath, os.ModePerm); err != nil {
+				return err
+			}
+		case SyncCopy:
+			if err := CopyFile(path.Join(p.Src, op.Path), dstPath, op.Mode, op.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Directory modification times are changed by the writes, so they're applied last.
+	sorted := append([]SyncOp{}, p.Ops...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i].Path, "/") > strings.Count(sorted[j].Path, "/")
+	})
+	for _, op := range sorted {
+		if op.Action == SyncMkdir && op.Path != "." {
+			if err := os.Chtimes(path.Join(p.Dst, op.Path), op.ModTime, op.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sameFile reports whether the destination file has the same size, modification time and contents.
+func sameFile(srcPath string, dstPath string, srcInfo fs.FileInfo) (bool, error) {
+	// A parent being a file in the destination means the file is missing as well.
+	dstInfo, err := os.Stat(dstPath)
+	if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if !dstInfo.Mode().IsRegular() || dstInfo.Size() != srcInfo.Size() {
+		return false, nil
+	}
+	if d := dstInfo.ModTime().Sub(srcInfo.ModTime()); d >= modTimeTolerance || d <= -modTimeTolerance {
+		return false, nil
+	}
+
+	srcSum, err := HashFile(srcPath)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := HashFile(dstPath)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(srcSum, dstSum), nil
+}
+
+// HashFile returns the SHA-256 digest of the file contents.
+func HashFile(p string) ([]byte, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return nil, err
+	}
+	return digest.Sum(nil), nil
+}
+
+// CopyFile copies the file, applying the mode and the modification time.
+// The contents are written to a temporary file first, so that the destination is replaced atomically.
+func CopyFile(srcPath string, dstPath string, mode fs.FileMode, modTime time.Time) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(path.Dir(dstPath), ".sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), mode.Perm()); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), modTime, modTime); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dstPath)
+}
@@ -0,0 +1,175 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var syncActionNames = map[int]string{
+	SyncMkdir:  "mkdir",
+	SyncCopy:   "copy",
+	SyncSkip:   "skip",
+	SyncRemove: "remove",
+}
+
+// writeTree creates the files under root. Paths ending with a slash are created as directories.
+func writeTree(t *testing.T, root string, tree map[string]string, modTime time.Time) {
+	t.Helper()
+	for p, contents := range tree {
+		fullPath := filepath.Join(root, filepath.FromSlash(p))
+		if strings.HasSuffix(p, "/") {
+			if err := os.MkdirAll(fullPath, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fullPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		src  map[string]string
+		// dst is nil if the destination doesn't exist.
+		dst map[string]string
+		// dstTimeShift moves the modification time of the destination files.
+		dstTimeShift time.Duration
+		exclude      []string
+		want         []string
+	}{
+		{
+			name: "missing destination",
+			src:  map[string]string{"a": "aaa", "dir/b": "bbb"},
+			want: []string{"copy a", "mkdir dir", "copy dir/b"},
+		},
+		{
+			name: "identical files are skipped",
+			src:  map[string]string{"a": "aaa"},
+			dst:  map[string]string{"a": "aaa"},
+			want: []string{"skip a"},
+		},
+		{
+			name:         "modification time within the FAT resolution",
+			src:          map[string]string{"a": "aaa"},
+			dst:          map[string]string{"a": "aaa"},
+			dstTimeShift: time.Second,
+			want:         []string{"skip a"},
+		},
+		{
+			name:         "different modification time",
+			src:          map[string]string{"a": "aaa"},
+			dst:          map[string]string{"a": "aaa"},
+			dstTimeShift: time.Hour,
+			want:         []string{"copy a"},
+		},
+		{
+			name: "different size",
+			src:  map[string]string{"a": "aaa"},
+			dst:  map[string]string{"a": "aaaa"},
+			want: []string{"copy a"},
+		},
+		{
+			name: "same size and time, different contents",
+			src:  map[string]string{"a": "aaa"},
+			dst:  map[string]string{"a": "bbb"},
+			want: []string{"copy a"},
+		},
+		{
+			name: "files missing from the source are removed",
+			src:  map[string]string{"a": "aaa"},
+			dst:  map[string]string{"a": "aaa", "b": "bbb"},
+			want: []string{"skip a", "remove b"},
+		},
+		{
+			name: "directories are removed as a whole",
+			src:  map[string]string{"a": "aaa"},
+			dst:  map[string]string{"a": "aaa", "dir/b": "bbb", "dir/c": "ccc"},
+			want: []string{"skip a", "remove dir"},
+		},
+		{
+			name: "file replaced with a directory",
+			src:  map[string]string{"a/b": "bbb"},
+			dst:  map[string]string{"a": "aaa"},
+			want: []string{"mkdir a", "remove a", "copy a/b"},
+		},
+		{
+			name:    "excluded paths are left alone on both sides",
+			src:     map[string]string{"a": "aaa", "objects/x": "xxx"},
+			dst:     map[string]string{"a": "aaa", "objects/y": "yyy"},
+			exclude: []string{"objects"},
+			want:    []string{"skip a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "src")
+			dst := filepath.Join(t.TempDir(), "dst")
+			writeTree(t, src, tt.src, modTime)
+			if tt.dst != nil {
+				writeTree(t, dst, tt.dst, modTime.Add(tt.dstTimeShift))
+			}
+
+			plan, err := PlanSync(src, dst, tt.exclude...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for _, op := range plan.Ops {
+				if op.Path != "." {
+					got = append(got, syncActionNames[op.Action]+" "+op.Path)
+				}
+			}
+			sort.Strings(got)
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if strings.Join(got, ", ") != strings.Join(want, ", ") {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSyncPlanApply(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	writeTree(t, src, map[string]string{"a": "new", "dir/b": "bbb"}, modTime)
+	writeTree(t, dst, map[string]string{"a": "old", "stale/c": "ccc"}, modTime)
+
+	plan, err := PlanSync(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second plan has nothing left to do.
+	plan, err = PlanSync(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := plan.Count(SyncCopy) + plan.Count(SyncRemove); n != 0 {
+		t.Errorf("%d operations remain after the sync", n)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "stale")); !os.IsNotExist(err) {
+		t.Errorf("stale directory wasn't removed: %v", err)
+	}
+}
@@ -1,8 +1,13 @@
 package cache
 
 import (
+	"encoding/json"
+	"io"
 	"os"
+	"path"
+	"time"
 
+	"github.com/zhk-kk/raftpm/utils/files"
 	"github.com/zhk-kk/raftpm/workspace/config"
 )
 
@@ -11,14 +16,40 @@ type Cache struct {
 	config *config.Config
 }
 
+func (c Cache) packagesPath() string { return path.Join(c.path, "packages") }
+func (c Cache) targetsPath() string  { return path.Join(c.path, "targets") }
+
+func (c Cache) detectionPath(targetName, host string) string {
+	return path.Join(c.targetsPath(), targetName, "detection", host+".json")
+}
+
+func (c Cache) integrationPath(name, host string) string {
+	return path.Join(c.packagesPath(), name, "integration", host+".json")
+}
+
+func (c Cache) packageArchivePath(name, version string) string {
+	return path.Join(c.packagesPath(), name, "archives", version+".raftpm")
+}
+
+func (c Cache) targetArchivePath(targetName, version string) string {
+	return path.Join(c.targetsPath(), targetName, "archives", version+".raftpm")
+}
+
 func NewCache(cachePath string, config *config.Config) *Cache {
 	l := Cache{path: cachePath, config: config}
+	// The original package files are needed to repair the installed packages, but they're
+	// only kept on request, since they double the space the packages take.
+	l.config.AddBool("keepArchives", false, false)
 	return &l
 }
 
+func (c *Cache) Dirs() []string { return []string{c.path} }
+
 func (c *Cache) Init() error {
-	if err := os.MkdirAll(c.path, os.ModePerm); err != nil {
-		return err
+	for _, dir := range c.Dirs() {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -27,3 +58,165 @@ func (c *Cache) Init() error {
 func (c *Cache) Load() error {
 	return nil
 }
+
+// DetectionResult is the cached outcome of an integration target's detection script.
+type DetectionResult struct {
+	Detected bool   `json:"detected"`
+	Details  string `json:"details,omitempty"`
+	// PkgVersion is the version of the integration scripts package, that produced the result.
+	PkgVersion string    `json:"pkgVersion"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// Detection returns the cached detection result of the target on the host.
+// If nothing is cached, false is returned.
+func (c *Cache) Detection(targetName string, host string) (DetectionResult, bool, error) {
+	result := DetectionResult{}
+	ok, err := readJSON(c.detectionPath(targetName, host), &result)
+	return result, ok, err
+}
+
+// PutDetection caches the detection result of the target on the host.
+func (c *Cache) PutDetection(targetName string, host string, result DetectionResult) error {
+	return writeJSON(c.detectionPath(targetName, host), result)
+}
+
+// IntegrationResult is the outcome of a single capability script run for a binary package.
+type IntegrationResult struct {
+	Capability string `json:"capability"`
+	Exe        string `json:"exe"`
+	TargetName string `json:"targetName,omitempty"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Integration is the cached integration state of a binary package on a host.
+type Integration struct {
+	PkgVersion   string              `json:"pkgVersion"`
+	IntegratedAt time.Time           `json:"integratedAt"`
+	Results      []IntegrationResult `json:"results"`
+}
+
+// Integration returns the cached integration state of the binary package on the host.
+// If nothing is cached, false is returned.
+func (c *Cache) Integration(name string, host string) (Integration, bool, error) {
+	integration := Integration{}
+	ok, err := readJSON(c.integrationPath(name, host), &integration)
+	return integration, ok, err
+}
+
+// PutIntegration caches the integration state of the binary package on the host.
+func (c *Cache) PutIntegration(name string, host string, integration Integration) error {
+	return writeJSON(c.integrationPath(name, host), integration)
+}
+
+// KeepArchives reports whether the original package files should be cached on install.
+func (c *Cache) KeepArchives() bool { return c.config.Bool("keepArchives") }
+
+// PackageArchive returns the path of the cached package file of the binary package version.
+// If it isn't cached, false is returned.
+func (c *Cache) PackageArchive(name string, version string) (string, bool, error) {
+	return statArchive(c.packageArchivePath(name, version))
+}
+
+// PutPackageArchive caches the package file of the binary package version.
+func (c *Cache) PutPackageArchive(name string, version string, r io.Reader) error {
+	return files.WriteReaderAtomic(c.packageArchivePath(name, version), r, 0644)
+}
+
+// DropPackageArchive removes the cached package file of the binary package version.
+func (c *Cache) DropPackageArchive(name string, version string) error {
+	if err := os.Remove(c.packageArchivePath(name, version)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// TargetArchive returns the path of the cached package file of the integration scripts version.
+// If it isn't cached, false is returned.
+func (c *Cache) TargetArchive(targetName string, version string) (string, bool, error) {
+	return statArchive(c.targetArchivePath(targetName, version))
+}
+
+// PutTargetArchive caches the package file of the integration scripts version.
+func (c *Cache) PutTargetArchive(targetName string, version string, r io.Reader) error {
+	return files.WriteReaderAtomic(c.targetArchivePath(targetName, version), r, 0644)
+}
+
+func statArchive(p string) (string, bool, error) {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return p, true, nil
+}
+
+// DropPackage removes all the data cached for the binary package, such as it's integration results.
+func (c *Cache) DropPackage(name string) error {
+	return os.RemoveAll(path.Join(c.packagesPath(), name))
+}
+
+// DropTarget removes all the data cached for the integration target, such as it's detection results.
+func (c *Cache) DropTarget(targetName string) error {
+	return os.RemoveAll(path.Join(c.targetsPath(), targetName))
+}
+
+// readJSON reads the cached JSON value. If the file is missing, false is returned.
+func readJSON(p string, v any) (bool, error) {
+	raw, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeJSON writes the JSON value, replacing the file atomically.
+func writeJSON(p string, v any) error {
+	raw, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return files.WriteFileAtomic(p, raw, 0644)
+}
+
+// PackageNames returns the names of the binary packages, which have cached data.
+func (c *Cache) PackageNames() ([]string, error) { return readDirNames(c.packagesPath()) }
+
+// TargetNames returns the names of the integration targets, which have cached data.
+func (c *Cache) TargetNames() ([]string, error) { return readDirNames(c.targetsPath()) }
+
+// PackageSize returns the size of the data cached for the binary package.
+func (c *Cache) PackageSize(name string) (int64, error) {
+	return files.DirSize(path.Join(c.packagesPath(), name))
+}
+
+// TargetSize returns the size of the data cached for the integration target.
+func (c *Cache) TargetSize(targetName string) (int64, error) {
+	return files.DirSize(path.Join(c.targetsPath(), targetName))
+}
+
+// readDirNames returns the names of the subdirectories. A missing directory has none.
+func readDirNames(p string) ([]string, error) {
+	entries, err := os.ReadDir(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
@@ -1,6 +1,8 @@
 package common
 
 type WorkspaceElement interface {
+	// Dirs returns the directories created by Init.
+	Dirs() []string
 	Init() error
 	Load() error
 }
@@ -1,39 +1,428 @@
 package config
 
-import "os"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/zhk-kk/raftpm/utils/files"
+)
+
+var (
+	ErrMalformedConfig = errors.New("malformed config file")
+	ErrMissingField    = errors.New("required config field is missing")
+	ErrUnknownField    = errors.New("unknown config field")
+	ErrInvalidValue    = errors.New("invalid config value")
+	ErrReadOnlyField   = errors.New("config field is managed by raftpm, and can't be changed")
+)
+
+// Names of the field types.
+const (
+	TypeBool       = "bool"
+	TypeString     = "string"
+	TypeInt        = "int"
+	TypeStringList = "stringList"
+	TypeDuration   = "duration"
+)
+
+// Config is a set of typed fields, persisted as a JSON object.
+//
+// Optional fields, which aren't set, read as their default values, and aren't written to the file.
+// Required fields have to be present in the file, their default values are written if they aren't set.
 type Config struct {
-	path       string
-	boolFields map[string]field[bool]
+	path   string
+	fields map[string]configField
+	// unknown keeps the fields of the file, which weren't added to the config, so that they're preserved.
+	unknown map[string]json.RawMessage
+	// readOnly lists the fields, which can't be changed by Parse and Unset.
+	readOnly map[string]bool
 }
 
 func NewConfig(configPath string) *Config {
 	c := Config{
-		path:       configPath,
-		boolFields: make(map[string]field[bool]),
+		path:     configPath,
+		fields:   make(map[string]configField),
+		unknown:  make(map[string]json.RawMessage),
+		readOnly: make(map[string]bool),
 	}
 	return &c
 }
 
 func (c *Config) AddBool(fieldName string, required bool, defaultValue bool) {
-	c.boolFields[fieldName] = field[bool]{Default: defaultValue, Required: required}
+	c.fields[fieldName] = &field[bool]{Default: defaultValue, Required: required}
+}
+
+func (c *Config) AddString(fieldName string, required bool, defaultValue string) {
+	c.fields[fieldName] = &field[string]{Default: defaultValue, Required: required}
+}
+
+// AddStringChoice adds a string field, which only accepts one of the provided values.
+func (c *Config) AddStringChoice(fieldName string, required bool, defaultValue string, choices []string) {
+	c.fields[fieldName] = &field[string]{Default: defaultValue, Required: required, validate: func(value string) error {
+		for _, choice := range choices {
+			if value == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of: %s", strings.Join(choices, ", "))
+	}}
+}
+
+func (c *Config) AddInt(fieldName string, required bool, defaultValue int) {
+	c.fields[fieldName] = &field[int]{Default: defaultValue, Required: required}
+}
+
+func (c *Config) AddStringList(fieldName string, required bool, defaultValue []string) {
+	c.fields[fieldName] = &field[[]string]{Default: defaultValue, Required: required}
+}
+
+// AddDuration adds a duration field, stored in the format of time.ParseDuration, e.g. `1h30m`.
+func (c *Config) AddDuration(fieldName string, required bool, defaultValue time.Duration) {
+	c.fields[fieldName] = &field[time.Duration]{Default: defaultValue, Required: required}
 }
 
-// Read() reads the config file.
+// MakeReadOnly prevents the field from being changed by Parse and Unset, since it's managed by raftpm itself.
+// The setters still change it.
+func (c *Config) MakeReadOnly(fieldName string) { c.readOnly[fieldName] = true }
+
+// Path returns the path of the config file.
+func (c *Config) Path() string { return c.path }
+
+// Bool returns the value of the bool field, or it's default if the value isn't set.
+func (c *Config) Bool(fieldName string) bool { return getField[bool](c, fieldName).Get() }
+
+// String returns the value of the string field, or it's default if the value isn't set.
+func (c *Config) String(fieldName string) string { return getField[string](c, fieldName).Get() }
+
+// Int returns the value of the int field, or it's default if the value isn't set.
+func (c *Config) Int(fieldName string) int { return getField[int](c, fieldName).Get() }
+
+// StringList returns the value of the string list field, or it's default if the value isn't set.
+func (c *Config) StringList(fieldName string) []string { return getField[[]string](c, fieldName).Get() }
+
+// Duration returns the value of the duration field, or it's default if the value isn't set.
+func (c *Config) Duration(fieldName string) time.Duration {
+	return getField[time.Duration](c, fieldName).Get()
+}
+
+func (c *Config) SetBool(fieldName string, value bool)     { getField[bool](c, fieldName).Set(value) }
+func (c *Config) SetString(fieldName string, value string) { getField[string](c, fieldName).Set(value) }
+func (c *Config) SetInt(fieldName string, value int)       { getField[int](c, fieldName).Set(value) }
+func (c *Config) SetStringList(fieldName string, value []string) {
+	getField[[]string](c, fieldName).Set(value)
+}
+func (c *Config) SetDuration(fieldName string, value time.Duration) {
+	getField[time.Duration](c, fieldName).Set(value)
+}
+
+// FieldInfo describes a field and it's current value.
+type FieldInfo struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Required bool   `json:"required"`
+	ReadOnly bool   `json:"readOnly"`
+	// IsSet is false if the value is the default one.
+	IsSet   bool `json:"isSet"`
+	Value   any  `json:"value"`
+	Default any  `json:"default"`
+}
+
+// Fields describes all the fields of the config, sorted by their names.
+func (c *Config) Fields() []FieldInfo {
+	infos := make([]FieldInfo, 0, len(c.fields))
+	for _, name := range c.names() {
+		info, _ := c.Field(name)
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Field describes the field with the provided name.
+func (c *Config) Field(fieldName string) (FieldInfo, error) {
+	f, ok := c.fields[fieldName]
+	if !ok {
+		return FieldInfo{}, fmt.Errorf("%w: `%s`", ErrUnknownField, fieldName)
+	}
+	info := f.info(fieldName)
+	info.ReadOnly = c.readOnly[fieldName]
+	return info, nil
+}
+
+// Parse sets the value of the field from it's string representation, validating it against the field type.
+// String lists are comma-separated, durations are in the format of time.ParseDuration.
+func (c *Config) Parse(fieldName string, value string) error {
+	f, ok := c.fields[fieldName]
+	if !ok {
+		return fmt.Errorf("%w: `%s`", ErrUnknownField, fieldName)
+	}
+	if c.readOnly[fieldName] {
+		return fmt.Errorf("%w: `%s`", ErrReadOnlyField, fieldName)
+	}
+	if err := f.parse(value); err != nil {
+		return fmt.Errorf("%w: field `%s` of type `%s`: %w", ErrInvalidValue, fieldName, f.typeName(), err)
+	}
+	return nil
+}
+
+// Unset resets the field to it's default value.
+func (c *Config) Unset(fieldName string) error {
+	f, ok := c.fields[fieldName]
+	if !ok {
+		return fmt.Errorf("%w: `%s`", ErrUnknownField, fieldName)
+	}
+	if c.readOnly[fieldName] {
+		return fmt.Errorf("%w: `%s`", ErrReadOnlyField, fieldName)
+	}
+	f.unset()
+	return nil
+}
+
+// FormatValue formats the value of a field, so that it could be passed to Parse.
+func FormatValue(value any) string {
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Read reads the config file, replacing the values of all the fields.
+// Fails if the file is malformed, or any of the required fields is missing from it.
 func (c *Config) Read() error {
+	raw, err := os.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return fmt.Errorf("%w: `%s`: %w", ErrMalformedConfig, c.path, err)
+	}
+
+	unknown := make(map[string]json.RawMessage)
+	for name, value := range values {
+		if _, ok := c.fields[name]; !ok {
+			unknown[name] = value
+		}
+	}
+
+	for _, name := range c.names() {
+		f := c.fields[name]
+		value, ok := values[name]
+		if !ok || string(value) == "null" {
+			if f.required() {
+				return fmt.Errorf("%w: `%s` in `%s`", ErrMissingField, name, c.path)
+			}
+			f.unset()
+			continue
+		}
+
+		if err := f.decode(value); err != nil {
+			return fmt.Errorf("%w: `%s`: field `%s`: %w", ErrMalformedConfig, c.path, name, err)
+		}
+	}
+	c.unknown = unknown
+
 	return nil
 }
 
-// Flush() flushes all the changes.
+// Flush writes the config file, replacing it atomically.
 func (c *Config) Flush() error {
-	if err := os.MkdirAll(c.path, os.ModePerm); err != nil {
+	values := make(map[string]json.RawMessage, len(c.fields)+len(c.unknown))
+	for name, value := range c.unknown {
+		values[name] = value
+	}
+
+	for name, f := range c.fields {
+		if !f.isSet() && !f.required() {
+			continue
+		}
+
+		value, err := f.encode()
+		if err != nil {
+			return fmt.Errorf("field `%s`: %w", name, err)
+		}
+		values[name] = value
+	}
+
+	raw, err := json.MarshalIndent(values, "", "    ")
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return files.WriteFileAtomic(c.path, append(raw, '\n'), 0644)
+}
+
+// names returns the names of all the fields in a sorted order.
+func (c *Config) names() []string {
+	names := make([]string, 0, len(c.fields))
+	for name := range c.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getField returns the field with the provided name and type.
+// Accessing fields, which weren't added, is a bug.
+func getField[T any](c *Config, fieldName string) *field[T] {
+	f, ok := c.fields[fieldName].(*field[T])
+	if !ok {
+		var zero T
+		panic(fmt.Sprintf("[BUG]: config field `%s` of type `%T` wasn't added", fieldName, zero))
+	}
+	return f
+}
+
+// configField is the type-independent interface of the fields.
+type configField interface {
+	typeName() string
+	info(name string) FieldInfo
+	required() bool
+	isSet() bool
+	unset()
+	parse(s string) error
+	decode(raw json.RawMessage) error
+	encode() (json.RawMessage, error)
 }
 
 type field[T any] struct {
 	Default  T
 	Required bool
+	Value    *T
+
+	// validate optionally restricts the values of the field.
+	validate func(value T) error
+}
+
+func (f *field[T]) Get() T {
+	if f.Value != nil {
+		return *f.Value
+	}
+	return f.Default
+}
+
+func (f *field[T]) Set(value T) { f.Value = &value }
+
+func (f *field[T]) typeName() string {
+	var zero T
+	switch any(zero).(type) {
+	case bool:
+		return TypeBool
+	case string:
+		return TypeString
+	case int:
+		return TypeInt
+	case []string:
+		return TypeStringList
+	case time.Duration:
+		return TypeDuration
+	default:
+		return fmt.Sprintf("%T", zero)
+	}
+}
+
+func (f *field[T]) info(name string) FieldInfo {
+	info := FieldInfo{
+		Name:     name,
+		Type:     f.typeName(),
+		Required: f.Required,
+		IsSet:    f.isSet(),
+		Value:    any(f.Get()),
+		Default:  any(f.Default),
+	}
+	// Durations are shown in the same format they're stored in.
+	if d, ok := info.Value.(time.Duration); ok {
+		info.Value = d.String()
+		info.Default = any(f.Default).(time.Duration).String()
+	}
+	return info
+}
+
+func (f *field[T]) parse(s string) error {
+	var value T
+	switch v := any(&value).(type) {
+	case *bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *string:
+		*v = s
+	case *int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*v = i
+	case *[]string:
+		*v = []string{}
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = d
+	default:
+		return fmt.Errorf("unsupported field type `%T`", value)
+	}
+
+	return f.set(value)
+}
+
+// set sets the value, if it's valid.
+func (f *field[T]) set(value T) error {
+	if f.validate != nil {
+		if err := f.validate(value); err != nil {
+			return err
+		}
+	}
+	f.Value = &value
+	return nil
+}
+
+func (f *field[T]) required() bool { return f.Required }
+func (f *field[T]) isSet() bool    { return f.Value != nil }
+func (f *field[T]) unset()         { f.Value = nil }
+
+func (f *field[T]) decode(raw json.RawMessage) error {
+	var value T
+	switch v := any(&value).(type) {
+	case *time.Duration:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*v = d
+	default:
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+	}
+
+	return f.set(value)
+}
+
+func (f *field[T]) encode() (json.RawMessage, error) {
+	value := f.Get()
+	if d, ok := any(value).(time.Duration); ok {
+		return json.Marshal(d.String())
+	}
+	return json.Marshal(value)
 }
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestConfig returns a config with a required, a few optional and a read-only field, stored in a temporary directory.
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+	c := NewConfig(filepath.Join(t.TempDir(), "config"))
+	c.AddBool("required", true, false)
+	c.AddStringChoice("choice", false, "a", []string{"a", "b"})
+	c.AddInt("number", false, 7)
+	c.AddInt("managed", false, 0)
+	c.MakeReadOnly("managed")
+	return c
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name       string
+		contents   string
+		wantErr    error
+		wantChoice string
+		wantNumber int
+	}{
+		{
+			name:       "optional fields default",
+			contents:   `{"required": true}`,
+			wantChoice: "a",
+			wantNumber: 7,
+		},
+		{
+			name:       "values are read",
+			contents:   `{"required": true, "choice": "b", "number": 3}`,
+			wantChoice: "b",
+			wantNumber: 3,
+		},
+		{
+			name:       "null reads as the default",
+			contents:   `{"required": true, "number": null}`,
+			wantChoice: "a",
+			wantNumber: 7,
+		},
+		{
+			name:       "unknown fields are ignored",
+			contents:   `{"required": true, "removed": "value"}`,
+			wantChoice: "a",
+			wantNumber: 7,
+		},
+		{
+			name:     "missing required field",
+			contents: `{"number": 3}`,
+			wantErr:  ErrMissingField,
+		},
+		{
+			name:     "wrong type",
+			contents: `{"required": true, "number": "three"}`,
+			wantErr:  ErrMalformedConfig,
+		},
+		{
+			name:     "not an object",
+			contents: `[]`,
+			wantErr:  ErrMalformedConfig,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t)
+			if err := os.WriteFile(c.Path(), []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := c.Read()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := c.String("choice"); got != tt.wantChoice {
+				t.Errorf("choice is %q, want %q", got, tt.wantChoice)
+			}
+			if got := c.Int("number"); got != tt.wantNumber {
+				t.Errorf("number is %d, want %d", got, tt.wantNumber)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   string
+		value   string
+		wantErr error
+	}{
+		{name: "bool", field: "required", value: "true"},
+		{name: "int", field: "number", value: "42"},
+		{name: "choice", field: "choice", value: "b"},
+		{name: "invalid choice", field: "choice", value: "c", wantErr: ErrInvalidValue},
+		{name: "invalid int", field: "number", value: "many", wantErr: ErrInvalidValue},
+		{name: "unknown field", field: "removed", value: "value", wantErr: ErrUnknownField},
+		{name: "read-only field", field: "managed", value: "1", wantErr: ErrReadOnlyField},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestConfig(t)
+			err := c.Parse(tt.field, tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			info, err := c.Field(tt.field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !info.IsSet || FormatValue(info.Value) != tt.value {
+				t.Errorf("field is %+v, want %q", info, tt.value)
+			}
+		})
+	}
+}
+
+func TestFlush(t *testing.T) {
+	c := newTestConfig(t)
+	if err := os.WriteFile(c.Path(), []byte(`{"required": true, "number": 3, "removed": "value"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unset("number"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Required fields are written, unset optional ones aren't, and unknown ones are preserved.
+	reread := NewConfig(c.Path())
+	reread.AddBool("required", true, false)
+	reread.AddInt("number", false, 7)
+	reread.AddString("removed", false, "")
+	if err := reread.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := reread.Field("number"); info.IsSet {
+		t.Errorf("unset field was written: %+v", info)
+	}
+	if got := reread.String("removed"); got != "value" {
+		t.Errorf("unknown field is %q, want it preserved", got)
+	}
+	if !reread.Bool("required") {
+		t.Errorf("required field wasn't written")
+	}
+}
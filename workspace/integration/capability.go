@@ -0,0 +1,201 @@
+package integration
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/workspace"
+	"github.com/zhk-kk/raftpm/workspace/cache"
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+var (
+	ErrCapabilityUnsupported = errors.New("no detected target supports the capability")
+)
+
+// Environment variables passed to the capability scripts.
+const (
+	// EnvWorkspace is the absolute path of the workspace.
+	EnvWorkspace = "RAFTPM_WORKSPACE"
+	// EnvCapability is the name of the requested capability, e.g. `desktopApp`.
+	EnvCapability = "RAFTPM_CAPABILITY"
+	// EnvTargetName is the name of the target, the script belongs to.
+	EnvTargetName = "RAFTPM_TARGET_NAME"
+	// EnvTargetType is the type of the target, e.g. `desktopEnvironment`. Only passed to detection scripts.
+	EnvTargetType = "RAFTPM_TARGET_TYPE"
+	// EnvAppName is the name of the binary package.
+	EnvAppName = "RAFTPM_APP_NAME"
+	// EnvAppVersion is the version of the binary package.
+	EnvAppVersion = "RAFTPM_APP_VERSION"
+	// EnvAppDescription is the `description` field of the package's `about`.
+	EnvAppDescription = "RAFTPM_APP_DESCRIPTION"
+	// EnvAppDir is the absolute path of the package's directory in the store.
+	EnvAppDir = "RAFTPM_APP_DIR"
+	// EnvAppExe is the absolute path of the launcher in the `links` directory.
+	EnvAppExe = "RAFTPM_APP_EXE"
+	// EnvAppExeName is the shell name of the executable.
+	EnvAppExeName = "RAFTPM_APP_EXE_NAME"
+	// EnvAppIcon is the absolute path of the package icon, or an empty string.
+	EnvAppIcon = "RAFTPM_APP_ICON"
+)
+
+// AppIntegration is the integration outcome of a single binary package.
+type AppIntegration struct {
+	Name    string
+	Version string
+	Results []cache.IntegrationResult
+}
+
+// Integrate runs the capability scripts of the detected targets for every installed binary package,
+// which requests the capability. The outcome is cached for every package, and returned.
+//
+// Capability scripts are run with `/bin/sh` from the integration scripts package directory,
+// the EnvXxx environment variables describe the application. Exit code 0 means success.
+func Integrate(w *workspace.Workspace, detections []Detection) ([]AppIntegration, error) {
+	packages, err := w.Store().Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the detected targets.
+	detected := make(map[string]bool)
+	for _, target := range DetectedTargets(detections) {
+		detected[target] = true
+	}
+
+	var targets []store.Package
+	for _, p := range packages {
+		if _, ok := p.Manifest.(manifest.IntegrationScriptsPkg); ok && detected[p.Name()] {
+			targets = append(targets, p)
+		}
+	}
+
+	host := Host()
+	var integrations []AppIntegration
+	for _, p := range packages {
+		// Only the active versions are integrated, the others aren't linked into the workspace.
+		binPkg, ok := p.Manifest.(manifest.BinaryPkg)
+		if !ok || !p.Active || len(binPkg.Capabilities) == 0 {
+			continue
+		}
+
+		app := AppIntegration{Name: binPkg.Name, Version: p.CommonInfo.PkgVersion.String()}
+		for _, request := range binPkg.Capabilities {
+			app.Results = append(app.Results, runCapability(w, p, binPkg, request, targets)...)
+		}
+
+		err := w.Cache().PutIntegration(binPkg.Name, host, cache.Integration{
+			PkgVersion:   app.Version,
+			IntegratedAt: time.Now().UTC(),
+			Results:      app.Results,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		integrations = append(integrations, app)
+	}
+
+	return integrations, nil
+}
+
+// runCapability runs the scripts of all the targets providing the requested capability.
+func runCapability(
+	w *workspace.Workspace, p store.Package, binPkg manifest.BinaryPkg,
+	request manifest.CapabilityRequest, targets []store.Package,
+) []cache.IntegrationResult {
+	var results []cache.IntegrationResult
+
+	env, err := capabilityEnv(w, p, binPkg, request)
+	if err != nil {
+		return []cache.IntegrationResult{{
+			Capability: request.Capability, Exe: request.Exe, Error: err.Error(),
+		}}
+	}
+
+	for _, target := range targets {
+		isPkg := target.Manifest.(manifest.IntegrationScriptsPkg)
+		for _, script := range isPkg.CapabilityScripts {
+			if script.Capability != request.Capability {
+				continue
+			}
+
+			result := cache.IntegrationResult{
+				Capability: request.Capability,
+				Exe:        request.Exe,
+				TargetName: isPkg.TargetName,
+			}
+			if err := runCapabilityScript(target, script, append(env, EnvTargetName+"="+isPkg.TargetName)); err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Success = true
+			}
+			results = append(results, result)
+		}
+	}
+
+	if len(results) == 0 {
+		return []cache.IntegrationResult{{
+			Capability: request.Capability, Exe: request.Exe, Error: ErrCapabilityUnsupported.Error(),
+		}}
+	}
+	return results
+}
+
+// capabilityEnv builds the environment variables describing the application.
+func capabilityEnv(
+	w *workspace.Workspace, p store.Package, binPkg manifest.BinaryPkg, request manifest.CapabilityRequest,
+) ([]string, error) {
+	workspacePath, err := filepath.Abs(w.Path())
+	if err != nil {
+		return nil, err
+	}
+	appDir, err := filepath.Abs(p.DataPath)
+	if err != nil {
+		return nil, err
+	}
+	exePath, err := filepath.Abs(w.Links().LinkPath(request.Exe))
+	if err != nil {
+		return nil, err
+	}
+
+	iconPath := ""
+	if binPkg.Icon != nil {
+		if iconPath, err = packageFilePath(p, *binPkg.Icon); err != nil {
+			return nil, fmt.Errorf("icon: %w", err)
+		}
+	}
+
+	return []string{
+		EnvWorkspace + "=" + workspacePath,
+		EnvCapability + "=" + request.Capability,
+		EnvAppName + "=" + binPkg.Name,
+		EnvAppVersion + "=" + p.CommonInfo.PkgVersion.String(),
+		EnvAppDescription + "=" + binPkg.About["description"],
+		EnvAppDir + "=" + appDir,
+		EnvAppExe + "=" + exePath,
+		EnvAppExeName + "=" + request.Exe,
+		EnvAppIcon + "=" + iconPath,
+	}, nil
+}
+
+// runCapabilityScript runs a single capability script of the target.
+func runCapabilityScript(target store.Package, script manifest.CapabilityScriptDesc, env []string) error {
+	scriptPath, err := packageFilePath(target, script.Path)
+	if err != nil {
+		return err
+	}
+
+	out, err := runScript(scriptPath, env)
+	if err != nil {
+		return err
+	}
+	if out.ExitCode != 0 {
+		return out.err(path.Base(scriptPath))
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zhk-kk/raftpm/pkg/common"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/workspace"
+	"github.com/zhk-kk/raftpm/workspace/cache"
+	"github.com/zhk-kk/raftpm/workspace/store"
+)
+
+var (
+	ErrScriptFailed       = errors.New("integration script failed")
+	ErrUnsupportedPkgPath = errors.New("unsupported package path type")
+)
+
+// Exit codes of detection scripts. Any other exit code is treated as an error.
+const (
+	DetectionExitDetected    = 0
+	DetectionExitNotDetected = 1
+)
+
+// scriptTimeout limits the running time of a single integration script.
+const scriptTimeout = 30 * time.Second
+
+// Detection is the detection result of a single integration target.
+type Detection struct {
+	TargetName string
+	TargetType string
+	cache.DetectionResult
+	// Cached is set if the result was taken from the cache.
+	Cached bool
+	// Err is set if the detection script failed. Failures are not cached.
+	Err error
+
+	pkg store.Package
+}
+
+// Host returns the key, under which the detection results of the current host are cached.
+func Host() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown-host"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, host)
+}
+
+// Detect runs the detection scripts of all the installed integration scripts packages, caching the results.
+// Results cached for the current host are reused, unless force is set, or the package was changed since.
+//
+// Detection scripts are run with `/bin/sh` from the package directory. Exit code 0 means the target
+// is present, 1 means it's not, anything else is a failure. The first line of the output may describe
+// the detected target, e.g. it's version.
+func Detect(w *workspace.Workspace, force bool) ([]Detection, error) {
+	packages, err := w.Store().Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	detections, err := PlanDetect(w.Cache(), packages, force)
+	if err != nil {
+		return nil, err
+	}
+
+	host := Host()
+	for i := range detections {
+		d := &detections[i]
+		if d.Cached {
+			continue
+		}
+
+		d.DetectionResult, d.Err = runDetection(w, d.pkg, d.pkg.Manifest.(manifest.IntegrationScriptsPkg))
+		if d.Err == nil {
+			if err := w.Cache().PutDetection(d.TargetName, host, d.DetectionResult); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return detections, nil
+}
+
+// PlanDetect decides which detection scripts of the packages have to be run, without running them.
+// The returned detections, which aren't Cached, are the ones Detect would run.
+func PlanDetect(c *cache.Cache, packages []store.Package, force bool) ([]Detection, error) {
+	host := Host()
+	var detections []Detection
+	for _, p := range packages {
+		isPkg, ok := p.Manifest.(manifest.IntegrationScriptsPkg)
+		if !ok {
+			continue
+		}
+
+		d := Detection{TargetName: isPkg.TargetName, TargetType: isPkg.TargetType, pkg: p}
+
+		if !force {
+			cached, ok, err := c.Detection(isPkg.TargetName, host)
+			if err != nil {
+				return nil, err
+			}
+			if ok && cached.PkgVersion == p.CommonInfo.PkgVersion.String() {
+				d.DetectionResult = cached
+				d.Cached = true
+			}
+		}
+
+		detections = append(detections, d)
+	}
+
+	return detections, nil
+}
+
+// DetectedTargets returns the names of all the targets, detected on the current host.
+func DetectedTargets(detections []Detection) []string {
+	var targets []string
+	for _, d := range detections {
+		if d.Err == nil && d.Detected {
+			targets = append(targets, d.TargetName)
+		}
+	}
+	return targets
+}
+
+// runDetection runs the detection script of the integration scripts package.
+func runDetection(w *workspace.Workspace, p store.Package, isPkg manifest.IntegrationScriptsPkg) (cache.DetectionResult, error) {
+	result := cache.DetectionResult{
+		PkgVersion: p.CommonInfo.PkgVersion.String(),
+		DetectedAt: time.Now().UTC(),
+	}
+
+	scriptPath, err := packageFilePath(p, isPkg.DetectionScriptPath)
+	if err != nil {
+		return result, err
+	}
+
+	workspacePath, err := filepath.Abs(w.Path())
+	if err != nil {
+		return result, err
+	}
+
+	out, err := runScript(scriptPath, []string{
+		EnvWorkspace + "=" + workspacePath,
+		EnvTargetName + "=" + isPkg.TargetName,
+		EnvTargetType + "=" + isPkg.TargetType,
+	})
+	if err != nil {
+		return result, err
+	}
+
+	switch out.ExitCode {
+	case DetectionExitDetected:
+		result.Detected = true
+	case DetectionExitNotDetected:
+		result.Detected = false
+	default:
+		return result, out.err(scriptPath)
+	}
+
+	line, _, _ := bufio.NewReader(bytes.NewReader(out.Stdout)).ReadLine()
+	result.Details = strings.TrimSpace(string(line))
+
+	return result, nil
+}
+
+// packageFilePath resolves the absolute path of the file inside the installed package.
+func packageFilePath(p store.Package, pkgPath common.PkgPath) (string, error) {
+	if pkgPath.Type != common.PkgPathTypeLocal {
+		return "", fmt.Errorf("%w: `%s`", ErrUnsupportedPkgPath, pkgPath)
+	}
+	return filepath.Abs(path.Join(p.DataPath, pkgPath.Path))
+}
+
+// scriptOutput is the outcome of a finished integration script.
+type scriptOutput struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// err describes the failure of the script.
+func (o scriptOutput) err(scriptPath string) error {
+	if msg := strings.TrimSpace(string(o.Stderr)); msg != "" {
+		return fmt.Errorf("%w: `%s` exited with code %d: %s", ErrScriptFailed, scriptPath, o.ExitCode, msg)
+	}
+	return fmt.Errorf("%w: `%s` exited with code %d", ErrScriptFailed, scriptPath, o.ExitCode)
+}
+
+// runScript runs the script with the additional environment variables.
+// Failing to run the script at all, or running out of time, is an error.
+func runScript(scriptPath string, env []string) (scriptOutput, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+
+	stdout := bytes.NewBuffer([]byte{})
+	stderr := bytes.NewBuffer([]byte{})
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", scriptPath)
+	cmd.Dir = path.Dir(scriptPath)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	out := scriptOutput{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+
+	if exitErr := (*exec.ExitError)(nil); errors.As(err, &exitErr) && ctx.Err() == nil {
+		out.ExitCode = exitErr.ExitCode()
+		return out, nil
+	} else if err != nil {
+		return out, fmt.Errorf("%w: `%s`: %w", ErrScriptFailed, scriptPath, err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,137 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/zhk-kk/raftpm/pkg/signing"
+)
+
+var (
+	ErrKeyExists         = errors.New("key already exists")
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrInvalidKeyName    = errors.New("invalid key name")
+	ErrKeyAlreadyTrusted = errors.New("key is already trusted")
+)
+
+const keyFileExt = ".pub"
+
+// Keyring is the store of the public keys, trusted to sign packages.
+type Keyring struct {
+	path string
+}
+
+// Key is a trusted public key.
+type Key struct {
+	Name      string
+	ID        string
+	PublicKey ed25519.PublicKey
+}
+
+func NewKeyring(keyringPath string) *Keyring {
+	k := Keyring{path: keyringPath}
+	return &k
+}
+
+func (k *Keyring) Dirs() []string { return []string{k.path} }
+
+func (k *Keyring) Init() error {
+	for _, dir := range k.Dirs() {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *Keyring) Load() error {
+	return nil
+}
+
+// Add trusts the public key under the provided name.
+func (k *Keyring) Add(name string, pub ed25519.PublicKey) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: `%s`", ErrInvalidKeyName, name)
+	}
+
+	keys, err := k.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if key.Name == name {
+			return fmt.Errorf("%w: `%s`", ErrKeyExists, name)
+		}
+		if key.PublicKey.Equal(pub) {
+			return fmt.Errorf("%w: as `%s`", ErrKeyAlreadyTrusted, key.Name)
+		}
+	}
+
+	return os.WriteFile(path.Join(k.path, name+keyFileExt), signing.EncodePublicKey(pub), 0644)
+}
+
+// Remove stops trusting the key with the provided name.
+func (k *Keyring) Remove(name string) error {
+	err := os.Remove(path.Join(k.path, name+keyFileExt))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: `%s`", ErrKeyNotFound, name)
+	}
+	return err
+}
+
+// Keys returns all the trusted keys, sorted by name.
+func (k *Keyring) Keys() ([]Key, error) {
+	entries, err := os.ReadDir(k.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != keyFileExt {
+			continue
+		}
+
+		raw, err := os.ReadFile(path.Join(k.path, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		pub, err := signing.ParsePublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("`%s`: %w", e.Name(), err)
+		}
+
+		keys = append(keys, Key{
+			Name:      strings.TrimSuffix(e.Name(), keyFileExt),
+			ID:        signing.KeyID(pub),
+			PublicKey: pub,
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// Lookup implements signing.Keyring.
+// If the keyring couldn't be read, no key is found.
+func (k *Keyring) Lookup(keyID string) (ed25519.PublicKey, bool) {
+	keys, err := k.Keys()
+	if err != nil {
+		return nil, false
+	}
+	for _, key := range keys {
+		if key.ID == keyID {
+			return key.PublicKey, true
+		}
+	}
+	return nil, false
+}
@@ -1,11 +1,27 @@
 package links
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/zhk-kk/raftpm/workspace/config"
 )
 
+var (
+	ErrLinkExists = errors.New("link already exists")
+)
+
+// launcherTemplate is the script placed into the links directory for every shell executable.
+// The target is resolved relative to the launcher, so that the workspace stays relocatable.
+const launcherTemplate = `#!/bin/sh
+# Generated by raftpm, do not edit.
+exec "$(dirname "$0")"/%s "$@"
+`
+
 type Links struct {
 	path   string
 	config *config.Config
@@ -16,9 +32,13 @@ func NewLinks(linksPath string, config *config.Config) *Links {
 	return &l
 }
 
+func (l *Links) Dirs() []string { return []string{l.path} }
+
 func (l *Links) Init() error {
-	if err := os.MkdirAll(l.path, os.ModePerm); err != nil {
-		return err
+	for _, dir := range l.Dirs() {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -27,3 +47,63 @@ func (l *Links) Init() error {
 func (l *Links) Load() error {
 	return nil
 }
+
+// Path returns the path of the links directory.
+func (l *Links) Path() string { return l.path }
+
+// LinkPath returns the path of the link entry with the provided name.
+func (l *Links) LinkPath(name string) string { return path.Join(l.path, name) }
+
+// Create creates an executable launcher named `name`, which runs the `target` executable.
+func (l *Links) Create(name string, target string) error {
+	linkPath := l.LinkPath(name)
+
+	if _, err := os.Lstat(linkPath); err == nil {
+		return fmt.Errorf("%w: `%s`", ErrLinkExists, name)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	relativeTarget, err := relativeTo(l.path, target)
+	if err != nil {
+		return err
+	}
+
+	launcher := fmt.Sprintf(launcherTemplate, shellQuote(filepath.ToSlash(relativeTarget)))
+	if err := os.WriteFile(linkPath, []byte(launcher), 0755); err != nil {
+		return err
+	}
+
+	// Apply the mode explicitly, since WriteFile is subject to umask.
+	return os.Chmod(linkPath, 0755)
+}
+
+// Remove deletes the link entries with the provided names.
+// Missing entries are ignored.
+func (l *Links) Remove(names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(path.Join(l.path, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// relativeTo returns the path to `target`, relative to the `base` directory.
+func relativeTo(base string, target string) (string, error) {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absBase, absTarget)
+}
+
+// shellQuote quotes the string for the use in a POSIX shell script.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
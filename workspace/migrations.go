@@ -0,0 +1,271 @@
+package workspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/utils/files"
+)
+
+var (
+	ErrNewerLayout = errors.New("workspace was created by a newer version of raftpm")
+)
+
+// migration upgrades the workspace layout by a single version.
+// Steps, which only bump the version, have no apply function.
+type migration struct {
+	description string
+	apply       func(w *Workspace) error
+}
+
+// migrations upgrade the workspace layout, the n-th one upgrades the version n to n+1.
+// Steps are only ever appended, the layout version of new workspaces is the number of steps.
+var migrations = []migration{
+	{
+		description: "record the layout version of the workspaces created before it was versioned",
+	},
+	{
+		description: "move the contents of the installed files into the content-addressed store objects",
+		apply: func(w *Workspace) error {
+			_, err := w.store.Deduplicate()
+			return err
+		},
+	},
+	{
+		description: "record the active versions of the installed binary packages",
+		apply: func(w *Workspace) error {
+			packages, err := w.store.Packages()
+			if err != nil {
+				return err
+			}
+
+			for _, p := range packages {
+				binPkg, ok := p.Manifest.(manifest.BinaryPkg)
+				if !ok {
+					continue
+				}
+
+				// Only one version could be linked before, so the highest one is the best guess.
+				latest, _, err := w.store.Latest(binPkg.Name)
+				if err != nil {
+					return err
+				}
+				if err := w.store.SetActiveVersion(binPkg.Name, latest.CommonInfo.PkgVersion.String()); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// LayoutVersion returns the version of the workspace layout, which this raftpm instance creates.
+func LayoutVersion() int { return len(migrations) }
+
+// backupManifestName is the file written into a backup once it's complete.
+const backupManifestName = "backup.json"
+
+// backupManifest describes a complete backup of the workspace.
+type backupManifest struct {
+	// Version is the layout version of the backed up workspace.
+	Version int `json:"version"`
+	// Dirs maps the names of the backed up directories to whether they existed.
+	Dirs map[string]bool `json:"dirs"`
+}
+
+// backedUpDirs lists the workspace directories, which are backed up before migrating.
+func (w Workspace) backedUpDirs() []string {
+	return []string{w.configDir(), w.storeDir(), w.linksDir(), w.cacheDir()}
+}
+
+// migrate upgrades the workspace layout to the current version, applying the migration steps in order.
+// A migration interrupted earlier is recovered first.
+func (w *Workspace) migrate() error {
+	if err := w.recoverMigration(); err != nil {
+		return fmt.Errorf("couldn't recover from an interrupted migration: %w", err)
+	}
+
+	version := w.config.Int("layoutVersion")
+	if version > LayoutVersion() {
+		return fmt.Errorf("%w: layout version %d, while only %d is supported, upgrade raftpm to use it",
+			ErrNewerLayout, version, LayoutVersion())
+	}
+
+	for ; version < LayoutVersion(); version++ {
+		if err := w.migrateStep(version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateStep upgrades the workspace from the provided layout version by a single step.
+// The workspace is backed up before the step, and restored if it fails.
+// The backup is removed once the step is done, so that it doesn't hold onto the replaced files.
+func (w *Workspace) migrateStep(version int) error {
+	step := migrations[version]
+
+	backupPath, err := w.backup(version)
+	if err != nil {
+		return fmt.Errorf("couldn't back up the workspace before migrating from layout %d: %w", version, err)
+	}
+
+	err = nil
+	if step.apply != nil {
+		err = step.apply(w)
+	}
+	if err == nil {
+		w.config.SetInt("layoutVersion", version+1)
+		err = w.config.Flush()
+	}
+	if err != nil {
+		if restoreErr := w.restore(backupPath); restoreErr != nil {
+			return fmt.Errorf("couldn't migrate from layout %d (%s): %w; couldn't restore the backup at `%s`: %w",
+				version, step.description, err, backupPath, restoreErr)
+		}
+		return fmt.Errorf("couldn't migrate from layout %d (%s), the workspace was restored: %w",
+			version, step.description, err)
+	}
+
+	return w.removeBackup(backupPath)
+}
+
+// recoverMigration cleans up after a migration step, which was interrupted. If the step wasn't done,
+// the workspace is restored from it's backup, otherwise the backup is just removed.
+// Incomplete backups are removed as well, since the step didn't start yet.
+func (w *Workspace) recoverMigration() error {
+	entries, err := os.ReadDir(w.backupsDir())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		backupPath := path.Join(w.backupsDir(), e.Name())
+		m, err := readBackupManifest(backupPath)
+		if !e.IsDir() || os.IsNotExist(err) {
+			if err := w.removeBackup(backupPath); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if m.Version == w.config.Int("layoutVersion") {
+			err = w.restore(backupPath)
+		} else {
+			err = w.removeBackup(backupPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backup copies the workspace directories into `backups/layout-<version>`, returning it's path.
+// Files are linked where possible, so that the backup is cheap.
+// The manifest is written last, so that an incomplete backup is never restored.
+func (w *Workspace) backup(version int) (string, error) {
+	backupPath := path.Join(w.backupsDir(), fmt.Sprintf("layout-%d", version))
+	if err := os.RemoveAll(backupPath); err != nil {
+		return backupPath, err
+	}
+
+	m := backupManifest{Version: version, Dirs: make(map[string]bool)}
+	for _, dir := range w.backedUpDirs() {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			m.Dirs[path.Base(dir)] = false
+			continue
+		} else if err != nil {
+			return backupPath, err
+		}
+
+		if err := files.LinkTree(dir, path.Join(backupPath, path.Base(dir))); err != nil {
+			w.removeBackup(backupPath)
+			return backupPath, err
+		}
+		m.Dirs[path.Base(dir)] = true
+	}
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return backupPath, err
+	}
+	if err := files.WriteFileAtomic(path.Join(backupPath, backupManifestName), raw, 0644); err != nil {
+		w.removeBackup(backupPath)
+		return backupPath, err
+	}
+
+	return backupPath, nil
+}
+
+// restore moves the backed up directories back into place, removing the ones, which didn't exist,
+// and rereads the workspace config. It can be repeated after an interruption.
+func (w *Workspace) restore(backupPath string) error {
+	m, err := readBackupManifest(backupPath)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range w.backedUpDirs() {
+		existed, ok := m.Dirs[path.Base(dir)]
+		if !ok {
+			continue
+		}
+		if !existed {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A missing backed up directory was already moved back.
+		backedUpDir := path.Join(backupPath, path.Base(dir))
+		if _, err := os.Stat(backedUpDir); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.Rename(backedUpDir, dir); err != nil {
+			return err
+		}
+	}
+
+	if err := w.removeBackup(backupPath); err != nil {
+		return err
+	}
+	return w.config.Read()
+}
+
+// removeBackup removes the backup, along with the backups directory, once it's empty.
+func (w *Workspace) removeBackup(backupPath string) error {
+	if err := os.RemoveAll(backupPath); err != nil {
+		return err
+	}
+	// Other backups may still be there.
+	os.Remove(w.backupsDir())
+	return nil
+}
+
+func readBackupManifest(backupPath string) (backupManifest, error) {
+	var m backupManifest
+	raw, err := os.ReadFile(path.Join(backupPath, backupManifestName))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return m, fmt.Errorf("malformed backup manifest `%s`: %w", backupPath, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,150 @@
+package workspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+var errStepFailed = errors.New("step failed")
+
+// newTestWorkspace creates a workspace with the provided migration steps, at the layout version 0.
+func newTestWorkspace(t *testing.T, steps []migration) *Workspace {
+	t.Helper()
+	original := migrations
+	migrations = steps
+	t.Cleanup(func() { migrations = original })
+
+	w := NewWorkspace(t.TempDir())
+	if err := w.Init(); err != nil {
+		t.Fatal(err)
+	}
+	w.config.SetInt("layoutVersion", 0)
+	if err := w.config.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// writeStoreFile returns a step, which writes the file into the store, failing afterwards if fail is set.
+func writeStoreFile(name string, fail bool) migration {
+	return migration{
+		description: "write " + name,
+		apply: func(w *Workspace) error {
+			if err := os.WriteFile(path.Join(w.storeDir(), name), []byte(name), 0644); err != nil {
+				return err
+			}
+			if fail {
+				return errStepFailed
+			}
+			return nil
+		},
+	}
+}
+
+func assertExists(t *testing.T, p string, want bool) {
+	t.Helper()
+	_, err := os.Stat(p)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	if exists := err == nil; exists != want {
+		t.Errorf("`%s` exists: %t, want %t", p, exists, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       []migration
+		wantErr     error
+		wantVersion int
+		// wantApplied is the number of steps, which were started.
+		wantApplied int
+		wantFiles   map[string]bool
+	}{
+		{
+			name:        "all steps are applied",
+			steps:       []migration{{description: "bump"}, writeStoreFile("a", false), writeStoreFile("b", false)},
+			wantVersion: 3,
+			wantApplied: 3,
+			wantFiles:   map[string]bool{"a": true, "b": true},
+		},
+		{
+			name:        "failed step is rolled back to the state just before it",
+			steps:       []migration{writeStoreFile("a", false), writeStoreFile("b", true), writeStoreFile("c", false)},
+			wantErr:     errStepFailed,
+			wantVersion: 1,
+			wantApplied: 2,
+			wantFiles:   map[string]bool{"a": true, "b": false, "c": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Wrap the steps, checking that each one is backed up, before it's applied.
+			applied := 0
+			steps := make([]migration, len(tt.steps))
+			for i, step := range tt.steps {
+				i, apply := i, step.apply
+				steps[i] = migration{description: step.description, apply: func(w *Workspace) error {
+					applied++
+					if _, err := readBackupManifest(path.Join(w.backupsDir(), fmt.Sprintf("layout-%d", i))); err != nil {
+						t.Errorf("step %d isn't backed up: %v", i, err)
+					}
+					if apply == nil {
+						return nil
+					}
+					return apply(w)
+				}}
+			}
+			w := newTestWorkspace(t, steps)
+
+			err := w.Load()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			if err := w.config.Read(); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.config.Int("layoutVersion"); got != tt.wantVersion {
+				t.Errorf("layout version is %d, want %d", got, tt.wantVersion)
+			}
+			for name, want := range tt.wantFiles {
+				assertExists(t, path.Join(w.storeDir(), name), want)
+			}
+			if applied != tt.wantApplied {
+				t.Errorf("%d steps were applied, want %d", applied, tt.wantApplied)
+			}
+			// No backup is left behind.
+			assertExists(t, w.backupsDir(), false)
+		})
+	}
+}
+
+func TestMigrateRecovery(t *testing.T) {
+	w := newTestWorkspace(t, []migration{writeStoreFile("a", false)})
+
+	// Interrupt the step right after it wrote the file.
+	if _, err := w.backup(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(w.storeDir(), "partial"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next load restores the workspace, and applies the step again.
+	reloaded := NewWorkspace(w.path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.config.Int("layoutVersion"); got != 1 {
+		t.Errorf("layout version is %d, want 1", got)
+	}
+	assertExists(t, path.Join(w.storeDir(), "partial"), false)
+	assertExists(t, path.Join(w.storeDir(), "a"), true)
+	assertExists(t, w.backupsDir(), false)
+}
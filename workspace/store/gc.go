@@ -0,0 +1,230 @@
+package store
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+)
+
+// Unused returns the installed versions of binary packages, which are neither active,
+// nor needed to satisfy the dependencies of the packages, which are kept.
+// Pinned packages keep only their active version, which is the one they're pinned to.
+func (s *Store) Unused() ([]Package, error) {
+	packages, err := s.Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(map[string]bool)
+	var queue []Package
+	for _, p := range packages {
+		if p.Active {
+			kept[p.DataPath] = true
+			queue = append(queue, p)
+		}
+	}
+
+	// Keep the dependencies of the kept packages, preferring the versions, which are kept anyway.
+	for len(queue) != 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		binPkg, ok := p.Manifest.(manifest.BinaryPkg)
+		if !ok {
+			continue
+		}
+		ranges, err := binPkg.DependencyRanges()
+		if err != nil {
+			return nil, err
+		}
+
+		for name, versionRange := range ranges {
+			var best *Package
+			satisfied := false
+			for i, candidate := range packages {
+				if _, ok := candidate.Manifest.(manifest.BinaryPkg); !ok || candidate.Name() != name ||
+					!versionRange(candidate.CommonInfo.PkgVersion) {
+					continue
+				}
+				if kept[candidate.DataPath] {
+					satisfied = true
+					break
+				}
+				if best == nil || candidate.CommonInfo.PkgVersion.GT(best.CommonInfo.PkgVersion) {
+					best = &packages[i]
+				}
+			}
+
+			if !satisfied && best != nil {
+				kept[best.DataPath] = true
+				queue = append(queue, *best)
+			}
+		}
+	}
+
+	var unused []Package
+	for _, p := range packages {
+		if !kept[p.DataPath] {
+			unused = append(unused, p)
+		}
+	}
+	return unused, nil
+}
+
+// Orphans returns the objects, which no installed package uses,
+// along with the leftovers of interrupted extractions.
+func (s *Store) Orphans() ([]string, error) {
+	referenced, err := s.referencedObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	err = filepath.WalkDir(s.objectsPath(), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if isOrphan(p, info, referenced) {
+			orphans = append(orphans, filepath.ToSlash(p))
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return orphans, err
+}
+
+// RemoveOrphans removes the objects, which are still not used by any installed package.
+func (s *Store) RemoveOrphans(orphans []string) error { return s.pruneObjects(orphans) }
+
+// Reclaimable returns the number of bytes, which removing the packages and the orphaned objects would free.
+// Objects used by the packages, which are kept, aren't counted, neither are the files linked from elsewhere.
+func (s *Store) Reclaimable(packages []Package, orphans []string) (int64, error) {
+	removed := make(map[string]bool)
+	for _, p := range packages {
+		removed[p.DataPath] = true
+	}
+
+	// Objects used by the packages, which are kept, stay in the store.
+	installed, err := s.Packages()
+	if err != nil {
+		return 0, err
+	}
+	kept := make(map[string]bool)
+	for _, p := range installed {
+		if removed[p.DataPath] {
+			continue
+		}
+		objectPaths, err := s.packageObjects(p)
+		if err != nil {
+			return 0, err
+		}
+		for _, objectPath := range objectPaths {
+			kept[filepath.ToSlash(objectPath)] = true
+		}
+	}
+
+	var size int64
+
+	// Links to every object, which would be removed along with the packages.
+	removedLinks := make(map[string]int)
+	objectInfos := make(map[string]fs.FileInfo)
+
+	for _, p := range packages {
+		hashes, err := p.hashes()
+		if err != nil {
+			return 0, err
+		}
+
+		// Files linked to the objects are accounted along with the objects.
+		objectLinks := make(map[string]bool)
+		for archivePath, fileHash := range hashes {
+			info, err := os.Lstat(p.dataFilePath(archivePath))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+
+			objectPath := s.objectPath(fileHash.SHA256, info.Mode())
+			objectInfo, err := os.Stat(objectPath)
+			if err == nil && os.SameFile(info, objectInfo) {
+				removedLinks[objectPath]++
+				objectInfos[objectPath] = objectInfo
+				objectLinks[filepath.ToSlash(p.dataFilePath(archivePath))] = true
+			} else if err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+		}
+
+		for _, dir := range []string{p.DataPath, p.metadataPath} {
+			dirSize, err := freedSize(dir, objectLinks)
+			if err != nil {
+				return 0, err
+			}
+			size += dirSize
+		}
+	}
+
+	for objectPath, links := range removedLinks {
+		if kept[filepath.ToSlash(objectPath)] {
+			continue
+		}
+		// Objects linked from elsewhere stay as well.
+		info := objectInfos[objectPath]
+		if linkedElsewhere(info, links+1) {
+			continue
+		}
+		size += info.Size()
+	}
+
+	for _, objectPath := range orphans {
+		info, err := os.Stat(objectPath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
+
+// freedSize returns the number of bytes, which removing the directory tree frees.
+// Files linked from elsewhere aren't counted, neither are the skipped ones.
+func freedSize(dir string, skip map[string]bool) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || skip[filepath.ToSlash(p)] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !linkedElsewhere(info, 1) {
+			size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return size, err
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver/v4"
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/pkg/paths"
+	"github.com/zhk-kk/raftpm/utils/files"
+	"github.com/zhk-kk/raftpm/workspace/config"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	root := t.TempDir()
+	s := NewStore(filepath.Join(root, "store"), config.NewConfig(filepath.Join(root, "config")))
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// addPackage records an installed binary package in the store metadata, without any data files.
+func addPackage(t *testing.T, s *Store, name string, version string, dependencies map[string]string) Package {
+	t.Helper()
+	info := manifest.PkgCommonInfo{PkgVersion: semver.MustParse(version), RaftpmVersion: semver.MustParse("0.1.0")}
+	raw, err := manifest.MarshalManifest(manifest.BinaryPkg{Name: name, Dependencies: dependencies}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadataPath := s.appMetadataPath(name, version)
+	if err := os.MkdirAll(metadataPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(metadataPath, paths.CompiledManifestName), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := s.loadPackage(metadataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// addFile writes a data file of the package, and records it in the package hash list.
+// The file is a copy of it's object, the way reflinked files look.
+func addFile(t *testing.T, s *Store, p Package, archivePath string, contents string) string {
+	t.Helper()
+	fileHash := pkg.NewFileHash([]byte(contents), 0644)
+
+	hashes, err := p.hashes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashes == nil {
+		hashes = pkg.Hashes{}
+	}
+	hashes[archivePath] = fileHash
+	raw, err := json.Marshal(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(p.metadataPath, paths.HashesName), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	objectPath := s.objectPath(fileHash.SHA256, fileHash.Mode)
+	for _, filePath := range []string{p.dataFilePath(archivePath), objectPath} {
+		if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return objectPath
+}
+
+func packageNames(packages []Package) []string {
+	names := make([]string, 0, len(packages))
+	for _, p := range packages {
+		names = append(names, p.Name()+" "+p.CommonInfo.PkgVersion.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestUnused(t *testing.T) {
+	s := newTestStore(t)
+
+	addPackage(t, s, "app", "0.9.0", nil)
+	addPackage(t, s, "app", "1.0.0", map[string]string{"lib": ">=1.0.0"})
+	addPackage(t, s, "lib", "1.0.0", nil)
+	addPackage(t, s, "tool", "1.0.0", nil)
+	addPackage(t, s, "tool", "2.0.0", nil)
+
+	for name, version := range map[string]string{"app": "1.0.0", "tool": "1.0.0"} {
+		if err := s.SetActiveVersion(name, version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Pin("tool"); err != nil {
+		t.Fatal(err)
+	}
+
+	unused, err := s.Unused()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lib has no active version, but the active app depends on it.
+	want := []string{"app 0.9.0", "tool 2.0.0"}
+	if got := packageNames(unused); strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	s := newTestStore(t)
+
+	kept := addPackage(t, s, "app", "1.0.0", nil)
+	old := addPackage(t, s, "app", "0.9.0", nil)
+	if err := s.SetActiveVersion("app", "1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	shared := addFile(t, s, kept, "cpdata/bin/app", "shared")
+	addFile(t, s, old, "cpdata/bin/app", "shared")
+	orphan := addFile(t, s, old, "cpdata/bin/old", "orphan")
+	if err := os.Remove(old.dataFilePath("cpdata/bin/old")); err != nil {
+		t.Fatal(err)
+	}
+	// Forget the file, so that nothing references it's object.
+	if err := os.WriteFile(path.Join(old.metadataPath, paths.HashesName), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	orphans, err := s.Orphans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0] != filepath.ToSlash(orphan) {
+		t.Fatalf("got %v, want only `%s`, not the referenced `%s`", orphans, orphan, shared)
+	}
+
+	// Objects used by the kept packages aren't counted.
+	size, err := s.Reclaimable(nil, orphans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len("orphan")) {
+		t.Errorf("reclaimable size is %d, want %d", size, len("orphan"))
+	}
+}
+
+func TestReclaimable(t *testing.T) {
+	s := newTestStore(t)
+	old := addPackage(t, s, "app", "0.9.0", nil)
+
+	// Package files without a hash list, one of them is linked from outside of the store, e.g. a backup.
+	for name, contents := range map[string]string{"solo": "solo", "shared": "shared"} {
+		filePath := old.dataFilePath("cpdata/" + name)
+		if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(old.dataFilePath("cpdata/shared"), filepath.Join(t.TempDir(), "shared")); err != nil {
+		t.Skipf("hardlinks are unsupported: %v", err)
+	}
+	info, err := os.Stat(old.dataFilePath("cpdata/shared"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files.LinkCount(info); !ok {
+		t.Skip("link counts are unknown")
+	}
+
+	manifestInfo, err := os.Stat(path.Join(old.metadataPath, paths.CompiledManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := s.Reclaimable([]Package{old}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len("solo")) + manifestInfo.Size(); size != want {
+		t.Errorf("reclaimable size is %d, want %d", size, want)
+	}
+}
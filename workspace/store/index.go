@@ -0,0 +1,230 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/blang/semver/v4"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/utils/files"
+)
+
+// Reasons for a package to be installed.
+const (
+	InstallReasonExplicit   = "explicit"
+	InstallReasonDependency = "dependency"
+)
+
+// IndexEntry is the record of an installed package in the store index.
+type IndexEntry struct {
+	// Name is the target name for integration scripts packages.
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Type        string    `json:"type"`
+	InstalledAt time.Time `json:"installedAt"`
+	Reason      string    `json:"reason"`
+	// SourceSHA256 is the hash of the package file. It's unknown for the packages,
+	// which were indexed after they had been installed.
+	SourceSHA256 string `json:"sourceSha256,omitempty"`
+	// Files are the paths of the data files, relative to the package data directory.
+	Files []string `json:"files"`
+	// Links are the names of the link entries, exported by a binary package.
+	Links []string `json:"links,omitempty"`
+}
+
+// storeIndex is the contents of `index.json`.
+type storeIndex struct {
+	Packages []IndexEntry `json:"packages"`
+}
+
+func (s Store) indexPath() string { return path.Join(s.path, "index.json") }
+
+// Index returns the records of all the installed packages, sorted by type, name and version.
+func (s *Store) Index() []IndexEntry { return append([]IndexEntry{}, s.index...) }
+
+// IndexEntry returns the record of the installed package. If it isn't indexed, false is returned.
+func (s *Store) IndexEntry(p Package) (IndexEntry, bool) {
+	for _, e := range s.index {
+		if e.matches(p) {
+			return e, true
+		}
+	}
+	return IndexEntry{}, false
+}
+
+func (e IndexEntry) matches(p Package) bool {
+	pkgType, _ := manifest.PkgType(p.Manifest)
+	return e.Type == pkgType && e.Name == p.Name() && e.Version == p.CommonInfo.PkgVersion.String()
+}
+
+// loadIndex reads the index, and reconciles it with the metadata of the installed packages.
+// Packages missing from it, e.g. in stores created before it, or changed by hand, are indexed
+// as explicitly installed at the time their metadata was written. Records of the packages,
+// which aren't installed anymore, are dropped. The index is only written, if it changed.
+func (s *Store) loadIndex() error {
+	var index storeIndex
+	raw, err := os.ReadFile(s.indexPath())
+	// A missing index is written, even if there's nothing to index yet.
+	changed := os.IsNotExist(err)
+	if err == nil {
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return fmt.Errorf("malformed store index `%s`: %w", s.indexPath(), err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	packages, err := s.Packages()
+	if err != nil {
+		return err
+	}
+
+	var entries []IndexEntry
+	for _, p := range packages {
+		found := false
+		for _, e := range index.Packages {
+			if e.matches(p) {
+				entries = append(entries, e)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		installedAt := time.Time{}
+		if info, err := os.Stat(p.metadataPath); err == nil {
+			installedAt = info.ModTime().UTC()
+		}
+		e, err := newIndexEntry(p, InstallReasonExplicit, installedAt, "")
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		changed = true
+	}
+	if len(entries) != len(index.Packages) {
+		changed = true
+	}
+
+	if !changed {
+		s.index = index.Packages
+		return nil
+	}
+	return s.writeIndex(entries)
+}
+
+// newIndexEntry describes the installed package.
+func newIndexEntry(p Package, reason string, installedAt time.Time, sourceSHA256 string) (IndexEntry, error) {
+	pkgType, err := manifest.PkgType(p.Manifest)
+	if err != nil {
+		return IndexEntry{}, err
+	}
+
+	e := IndexEntry{
+		Name:         p.Name(),
+		Version:      p.CommonInfo.PkgVersion.String(),
+		Type:         pkgType,
+		InstalledAt:  installedAt,
+		Reason:       reason,
+		SourceSHA256: sourceSHA256,
+		Files:        []string{},
+	}
+
+	err = filepath.WalkDir(p.DataPath, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(p.DataPath, walkPath)
+		if err != nil {
+			return err
+		}
+		e.Files = append(e.Files, filepath.ToSlash(relativePath))
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return IndexEntry{}, err
+	}
+
+	if binPkg, ok := p.Manifest.(manifest.BinaryPkg); ok {
+		for name := range binPkg.BinShellExe {
+			e.Links = append(e.Links, name)
+		}
+		sort.Strings(e.Links)
+	}
+
+	return e, nil
+}
+
+// putIndexEntry adds the record of the package to the index, replacing the existing one.
+func (s *Store) putIndexEntry(p Package, e IndexEntry) error {
+	entries := []IndexEntry{}
+	for _, other := range s.index {
+		if !other.matches(p) {
+			entries = append(entries, other)
+		}
+	}
+	return s.writeIndex(append(entries, e))
+}
+
+// dropIndexEntry removes the record of the package from the index.
+func (s *Store) dropIndexEntry(p Package) error {
+	entries := []IndexEntry{}
+	for _, other := range s.index {
+		if !other.matches(p) {
+			entries = append(entries, other)
+		}
+	}
+	return s.writeIndex(entries)
+}
+
+// writeIndex replaces the index atomically. The loaded index only changes, if the write succeeds.
+func (s *Store) writeIndex(entries []IndexEntry) error {
+	if entries == nil {
+		entries = []IndexEntry{}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type < entries[j].Type
+		}
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		vi, errI := semver.Parse(entries[i].Version)
+		vj, errJ := semver.Parse(entries[j].Version)
+		if errI != nil || errJ != nil {
+			return entries[i].Version < entries[j].Version
+		}
+		return vi.LT(vj)
+	})
+
+	raw, err := json.MarshalIndent(storeIndex{Packages: entries}, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := files.WriteFileAtomic(s.indexPath(), raw, 0644); err != nil {
+		return fmt.Errorf("couldn't write the store index: %w", err)
+	}
+
+	s.index = entries
+	return nil
+}
+
+// hashSource returns the hash of the package file.
+func hashSource(r io.ReaderAt, size int64) (string, error) {
+	digest := sha256.New()
+	if _, err := io.Copy(digest, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+)
+
+func TestLoadIndex(t *testing.T) {
+	s := newTestStore(t)
+	addPackage(t, s, "app", "1.0.0", nil)
+	addPackage(t, s, "lib", "1.0.0", nil)
+
+	// The index knows lib as a dependency, misses app, and still lists the removed tool.
+	raw, err := json.Marshal(storeIndex{Packages: []IndexEntry{
+		{Name: "lib", Version: "1.0.0", Type: manifest.TypeBinaryPkg, Reason: InstallReasonDependency},
+		{Name: "tool", Version: "1.0.0", Type: manifest.TypeBinaryPkg, Reason: InstallReasonExplicit},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(s.indexPath(), raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"app 1.0.0 explicit", "lib 1.0.0 dependency"}
+	index := s.Index()
+	if len(index) != len(want) {
+		t.Fatalf("got %v, want %v", index, want)
+	}
+	for i, e := range index {
+		if got := e.Name + " " + e.Version + " " + e.Reason; got != want[i] {
+			t.Errorf("entry %d is %q, want %q", i, got, want[i])
+		}
+	}
+	if index[0].InstalledAt.IsZero() {
+		t.Errorf("app is indexed without the install time")
+	}
+
+	// The reconciled index is written back.
+	reloaded := NewStore(s.path, s.config)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Index()) != len(want) {
+		t.Errorf("reloaded index is %v, want %v", reloaded.Index(), want)
+	}
+}
@@ -0,0 +1,262 @@
+package store
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/paths"
+	"github.com/zhk-kk/raftpm/utils/files"
+)
+
+// Contents of the package data files are kept in the content-addressed `objects` directory,
+// and the package trees are built from links to them, so that identical files take space only once.
+// On filesystems without hardlinks or reflinks the files are stored in the package trees directly.
+
+func (s Store) objectsPath() string { return path.Join(s.path, "objects") }
+
+// objectPath returns the path of the object with the provided contents and permissions.
+// The permissions are a part of the key, since they're shared by all the links.
+func (s Store) objectPath(sha256 string, mode fs.FileMode) string {
+	return path.Join(s.objectsPath(), sha256[:2], fmt.Sprintf("%s-%03o", sha256[2:], mode.Perm()))
+}
+
+// placeObject moves the verified file at tmpPath to destPath, sharing the contents with an identical object.
+// If there's no such object yet, or the existing one was corrupted, the file becomes one.
+func (s *Store) placeObject(tmpPath string, destPath string, fileHash pkg.FileHash) error {
+	objectPath := s.objectPath(fileHash.SHA256, fileHash.Mode)
+
+	// The existing object is checked, so that a corrupted one isn't spread to the new files.
+	if err := s.dropBrokenObject(fileHash); err != nil {
+		return err
+	}
+	if _, err := os.Stat(objectPath); err == nil {
+		os.Remove(tmpPath)
+		return files.LinkOrCopy(objectPath, destPath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(objectPath), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, objectPath); err != nil {
+		return err
+	}
+
+	err := files.Link(objectPath, destPath)
+	if errors.Is(err, files.ErrLinksUnsupported) {
+		// The object can't be shared, so it's only a waste of space.
+		return os.Rename(objectPath, destPath)
+	}
+	return err
+}
+
+// Deduplicate replaces the files of the installed packages, which are identical to the existing objects,
+// with links to them. Files without an object become ones. Returns the number of replaced files.
+//
+// It's meant for stores populated without going through Install, e.g. cloned ones.
+func (s *Store) Deduplicate() (int, error) {
+	packages, err := s.Packages()
+	if err != nil {
+		return 0, err
+	}
+
+	replaced := 0
+	for _, p := range packages {
+		hashes, err := p.hashes()
+		if err != nil {
+			return replaced, err
+		}
+
+		for archivePath, fileHash := range hashes {
+			ok, err := s.deduplicateFile(p.dataFilePath(archivePath), fileHash)
+			if errors.Is(err, files.ErrLinksUnsupported) {
+				return replaced, nil
+			} else if err != nil {
+				return replaced, err
+			}
+			if ok {
+				replaced++
+			}
+		}
+	}
+
+	return replaced, nil
+}
+
+// deduplicateFile links the file to it's object. Returns true if the file was replaced with a link.
+func (s *Store) deduplicateFile(filePath string, fileHash pkg.FileHash) (bool, error) {
+	info, err := os.Lstat(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	objectHash := pkg.FileHash{SHA256: fileHash.SHA256, Size: fileHash.Size, Mode: info.Mode().Perm()}
+	objectPath := s.objectPath(objectHash.SHA256, objectHash.Mode)
+	objectInfo, err := os.Stat(objectPath)
+	if err == nil && os.SameFile(info, objectInfo) {
+		return false, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	// The file could've been changed since it was installed, so the recorded hash can't be trusted.
+	digest, err := files.HashFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	if hex.EncodeToString(digest) != fileHash.SHA256 {
+		return false, nil
+	}
+
+	// Neither can the object, a corrupted one is replaced by the file.
+	if objectInfo != nil {
+		if err := s.dropBrokenObject(objectHash); err != nil {
+			return false, err
+		}
+		if _, err := os.Stat(objectPath); os.IsNotExist(err) {
+			objectInfo = nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	if objectInfo == nil {
+		if err := os.MkdirAll(path.Dir(objectPath), os.ModePerm); err != nil {
+			return false, err
+		}
+		return false, files.Link(filePath, objectPath)
+	}
+
+	// Replace the file atomically, linking the object under a temporary name first.
+	tmpPath := path.Join(path.Dir(filePath), ".dedup-"+path.Base(filePath))
+	os.Remove(tmpPath)
+	if err := files.Link(objectPath, tmpPath); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// packageObjects returns the paths of the objects, which the package files could be linked to.
+// Besides the object named after the recorded mode, the one named after the current mode of the file
+// is included, since the file could've been deduplicated after it's mode was changed.
+func (s *Store) packageObjects(p Package) ([]string, error) {
+	hashes, err := p.hashes()
+	if err != nil {
+		return nil, err
+	}
+
+	var objectPaths []string
+	for archivePath, fileHash := range hashes {
+		objectPaths = append(objectPaths, s.objectPath(fileHash.SHA256, expectedMode(fileHash)))
+
+		info, err := os.Lstat(p.dataFilePath(archivePath))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if info.Mode().Perm() != expectedMode(fileHash) {
+			objectPaths = append(objectPaths, s.objectPath(fileHash.SHA256, info.Mode()))
+		}
+	}
+	return objectPaths, nil
+}
+
+// referencedObjects returns the objects, which the files of the installed packages could be linked to.
+// The paths are slash separated.
+func (s *Store) referencedObjects() (map[string]bool, error) {
+	packages, err := s.Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, p := range packages {
+		objectPaths, err := s.packageObjects(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, objectPath := range objectPaths {
+			referenced[filepath.ToSlash(objectPath)] = true
+		}
+	}
+	return referenced, nil
+}
+
+// isOrphan reports whether the object isn't used by any installed package. Objects are only links
+// to the package files, so removing one never loses data, at worst the files stop sharing it.
+// Still, where the link count is known, objects linked from elsewhere, e.g. from the files
+// of the packages without a hash list, are kept.
+func isOrphan(objectPath string, info fs.FileInfo, referenced map[string]bool) bool {
+	return !referenced[filepath.ToSlash(objectPath)] && !linkedElsewhere(info, 1)
+}
+
+// linkedElsewhere reports whether the file has more links, than the provided number of the known ones.
+// Where the link count is unknown, the file is assumed to have only the known links.
+func linkedElsewhere(info fs.FileInfo, known int) bool {
+	count, ok := files.LinkCount(info)
+	return ok && count > uint64(known)
+}
+
+// pruneObjects removes the provided objects, which aren't used by any installed package anymore.
+func (s *Store) pruneObjects(objectPaths []string) error {
+	if len(objectPaths) == 0 {
+		return nil
+	}
+	referenced, err := s.referencedObjects()
+	if err != nil {
+		return err
+	}
+
+	for _, objectPath := range objectPaths {
+		info, err := os.Stat(objectPath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if isOrphan(objectPath, info, referenced) {
+			if err := os.Remove(objectPath); err != nil {
+				return err
+			}
+			removeIfEmpty(path.Dir(objectPath))
+		}
+	}
+	return nil
+}
+
+// hashes reads the hash list of the installed package.
+// Packages installed without a hash list have no hashes.
+func (p Package) hashes() (pkg.Hashes, error) {
+	raw, err := os.ReadFile(path.Join(p.metadataPath, paths.HashesName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return pkg.ParseHashes(raw)
+}
+
+// dataFilePath returns the path of the installed file, given it's path in the package archive.
+func (p Package) dataFilePath(archivePath string) string {
+	_, relativePath, _ := strings.Cut(archivePath, "/")
+	return path.Join(p.DataPath, relativePath)
+}
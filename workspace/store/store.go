@@ -1,37 +1,493 @@
 package store
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path"
+	"time"
 
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/pkg/paths"
+	"github.com/zhk-kk/raftpm/utils/files"
 	"github.com/zhk-kk/raftpm/workspace/config"
 )
 
+var (
+	ErrAlreadyInstalled = errors.New("package is already installed")
+	ErrRepairMismatch   = errors.New("package file doesn't match the installed package")
+)
+
 type Store struct {
 	path   string
 	config *config.Config
+
+	// index records the installed packages, it's read by Load.
+	index []IndexEntry
 }
 
 func (s Store) appsPath() string     { return path.Join(s.path, "apps") }
 func (s Store) iscriptsPath() string { return path.Join(s.path, "iscripts") }
+func (s Store) metadataPath() string { return path.Join(s.path, "metadata") }
+
+func (s Store) appPath(name, version string) string { return path.Join(s.appsPath(), name, version) }
+func (s Store) iscriptPath(targetName string) string {
+	return path.Join(s.iscriptsPath(), targetName)
+}
+func (s Store) appMetadataPath(name, version string) string {
+	return path.Join(s.metadataPath(), "apps", name, version)
+}
+func (s Store) iscriptMetadataPath(targetName string) string {
+	return path.Join(s.metadataPath(), "iscripts", targetName)
+}
+
+// Package describes a package installed into the store.
+type Package struct {
+	Manifest   interface{}
+	CommonInfo manifest.PkgCommonInfo
+	DataPath   string
+	// Active is set for the version of a binary package, which is linked into the workspace.
+	// Integration scripts packages are always active.
+	Active bool
+
+	metadataPath string
+}
+
+// Name returns the name of the package.
+// For integration scripts packages the target name is returned.
+func (p Package) Name() string {
+	switch pkgManifest := p.Manifest.(type) {
+	case manifest.BinaryPkg:
+		return pkgManifest.Name
+	case manifest.IntegrationScriptsPkg:
+		return pkgManifest.TargetName
+	default:
+		return ""
+	}
+}
 
 func NewStore(storePath string, config *config.Config) *Store {
 	l := Store{path: storePath, config: config}
+	// Pinned packages keep their active version, until they're unpinned.
+	l.config.AddStringList("pinned", false, []string{})
 	return &l
 }
 
+func (s *Store) Dirs() []string {
+	return []string{s.path, s.appsPath(), s.iscriptsPath(), s.metadataPath(), s.objectsPath()}
+}
+
 func (s *Store) Init() error {
-	if err := os.MkdirAll(s.appsPath(), os.ModePerm); err != nil {
+	for _, dir := range s.Dirs() {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Store) Load() error {
+	return s.loadIndex()
+}
+
+// Install unpacks the compiled package into the store.
+// Binary packages are placed into `apps/<name>/<version>`,
+// integration scripts packages into `iscripts/<targetName>`.
+// The decoded package metadata is kept in the `metadata` directory of the store.
+// The package is recorded in the store index, along with the reason it was installed for.
+// Another version of a pinned binary package is refused, before anything is extracted.
+func (s *Store) Install(r io.ReaderAt, size int64, reason string) (Package, error) {
+	p := Package{}
+
+	compiled, err := pkg.OpenPackage(r, size)
+	if err != nil {
+		return p, err
+	}
+	p.Manifest = compiled.Manifest()
+	p.CommonInfo = compiled.CommonInfo()
+
+	if err := pkg.CheckRaftpmVersion(p.CommonInfo); err != nil {
+		return p, err
+	}
+
+	// Figure out where the package belongs.
+	switch pkgManifest := p.Manifest.(type) {
+	case manifest.BinaryPkg:
+		p.DataPath = s.appPath(pkgManifest.Name, p.CommonInfo.PkgVersion.String())
+		p.metadataPath = s.appMetadataPath(pkgManifest.Name, p.CommonInfo.PkgVersion.String())
+	case manifest.IntegrationScriptsPkg:
+		p.DataPath = s.iscriptPath(pkgManifest.TargetName)
+		p.metadataPath = s.iscriptMetadataPath(pkgManifest.TargetName)
+	default:
+		return p, fmt.Errorf("[BUG]: Install() got a package type that it couldn't process")
+	}
+
+	// Pinned packages are refused before anything is extracted.
+	if _, ok := p.Manifest.(manifest.BinaryPkg); ok {
+		if err := s.CheckNotPinned(p.Name(), p.CommonInfo.PkgVersion); err != nil {
+			return p, err
+		}
+	}
+
+	if _, err := os.Stat(p.DataPath); err == nil {
+		return p, fmt.Errorf("%w: `%s`", ErrAlreadyInstalled, p.DataPath)
+	} else if !os.IsNotExist(err) {
+		return p, err
+	}
+
+	// Packages compiled without a hash list can't be verified.
+	hashes, err := compiled.Hashes()
+	if errors.Is(err, pkg.ErrNoHashList) {
+		hashes = nil
+	} else if err != nil {
+		return p, err
+	}
+
+	// Extract the package, cleaning up after a failure.
+	if err := s.extractEntries(compiled, compiled.DataEntries(), p.DataPath, hashes, true); err != nil {
+		s.Remove(p)
+		return p, fmt.Errorf("couldn't extract the package data: %w", err)
+	}
+
+	if err := s.extractEntries(compiled, compiled.MetadataEntries(), p.metadataPath, nil, false); err != nil {
+		s.Remove(p)
+		return p, fmt.Errorf("couldn't extract the package metadata: %w", err)
+	}
+
+	sourceSHA256, err := hashSource(r, size)
+	if err != nil {
+		s.Remove(p)
+		return p, err
+	}
+	e, err := newIndexEntry(p, reason, time.Now().UTC(), sourceSHA256)
+	if err != nil {
+		s.Remove(p)
+		return p, err
+	}
+	if err := s.putIndexEntry(p, e); err != nil {
+		s.Remove(p)
+		return p, err
+	}
+
+	return p, nil
+}
+
+// Path returns the path of the store directory.
+func (s *Store) Path() string { return s.path }
+
+// PlanClone plans an incremental sync of the store contents over to the destination store.
+// Objects aren't cloned, the destination has to be deduplicated after the sync instead,
+// so that the links survive the clone.
+func (s *Store) PlanClone(dest *Store) (*files.SyncPlan, error) {
+	return files.PlanSync(s.path, dest.path, path.Base(s.objectsPath()))
+}
+
+// Packages returns all the packages installed into the store.
+func (s *Store) Packages() ([]Package, error) {
+	var metadataPaths []string
+
+	// Binary packages are stored as `apps/<name>/<version>`.
+	appsMetadataPath := path.Join(s.metadataPath(), "apps")
+	names, err := readDirNames(appsMetadataPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		versions, err := readDirNames(path.Join(appsMetadataPath, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range versions {
+			metadataPaths = append(metadataPaths, s.appMetadataPath(name, version))
+		}
+	}
+
+	// Integration scripts packages are stored as `iscripts/<targetName>`.
+	targets, err := readDirNames(path.Join(s.metadataPath(), "iscripts"))
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		metadataPaths = append(metadataPaths, s.iscriptMetadataPath(target))
+	}
+
+	packages := make([]Package, 0, len(metadataPaths))
+	for _, metadataPath := range metadataPaths {
+		p, err := s.loadPackage(metadataPath)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, p)
+	}
+
+	return packages, nil
+}
+
+// Lookup returns all the installed packages with the provided name.
+// Integration scripts packages are looked up by their target name.
+func (s *Store) Lookup(name string) ([]Package, error) {
+	packages, err := s.Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Package
+	for _, p := range packages {
+		if p.Name() == name {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// Dependents returns the installed packages, which depend on the provided one,
+// and cannot be satisfied by any other installed package.
+func (s *Store) Dependents(p Package) ([]Package, error) {
+	packages, err := s.Packages()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Package
+	for _, other := range packages {
+		binPkg, ok := other.Manifest.(manifest.BinaryPkg)
+		if !ok || other.DataPath == p.DataPath {
+			continue
+		}
+
+		ranges, err := binPkg.DependencyRanges()
+		if err != nil {
+			return nil, fmt.Errorf("`%s`: %w", other.Name(), err)
+		}
+
+		versionRange, ok := ranges[p.Name()]
+		if !ok || !versionRange(p.CommonInfo.PkgVersion) {
+			continue
+		}
+
+		// Check if the dependency is satisfied by some other version.
+		satisfied := false
+		for _, alt := range packages {
+			if alt.DataPath != p.DataPath && alt.Name() == p.Name() && versionRange(alt.CommonInfo.PkgVersion) {
+				satisfied = true
+				break
+			}
+		}
+		if !satisfied {
+			result = append(result, other)
+		}
+	}
+
+	return result, nil
+}
+
+// Remove deletes the installed package from the store, along with the objects only it was using.
+func (s *Store) Remove(p Package) error {
+	objectPaths, err := s.packageObjects(p)
+	if err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(s.iscriptsPath(), os.ModePerm); err != nil {
+	if err := os.RemoveAll(p.DataPath); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(p.metadataPath); err != nil {
+		return err
+	}
+	if err := s.pruneObjects(objectPaths); err != nil {
 		return err
 	}
+	if err := s.dropIndexEntry(p); err != nil {
+		return err
+	}
+
+	// Remove the per-name directories of binary packages, once the last version is gone.
+	if binPkg, ok := p.Manifest.(manifest.BinaryPkg); ok {
+		if p.Active {
+			if err := s.clearActiveVersion(binPkg.Name); err != nil {
+				return err
+			}
+		}
+		removeIfEmpty(path.Dir(p.DataPath))
+		removeIfEmpty(path.Dir(p.metadataPath))
+	}
 
 	return nil
 }
 
-func (s *Store) Load() error {
+// loadPackage reads the description of an installed package from it's metadata directory.
+func (s *Store) loadPackage(metadataPath string) (Package, error) {
+	p := Package{metadataPath: metadataPath}
+
+	rawManifest, err := os.ReadFile(path.Join(metadataPath, paths.CompiledManifestName))
+	if err != nil {
+		return p, err
+	}
+
+	p.Manifest, err = manifest.ParseManifest(rawManifest, &p.CommonInfo)
+	if err != nil {
+		return p, fmt.Errorf("couldn't parse the manifest of `%s`: %w", metadataPath, err)
+	}
+
+	switch pkgManifest := p.Manifest.(type) {
+	case manifest.BinaryPkg:
+		p.DataPath = s.appPath(pkgManifest.Name, p.CommonInfo.PkgVersion.String())
+
+		active, _, err := s.ActiveVersion(pkgManifest.Name)
+		if err != nil {
+			return p, err
+		}
+		p.Active = active == p.CommonInfo.PkgVersion.String()
+	case manifest.IntegrationScriptsPkg:
+		p.DataPath = s.iscriptPath(pkgManifest.TargetName)
+		p.Active = true
+	}
+
+	return p, nil
+}
+
+// extractEntries extracts the package entries into `dest`, preserving the file modes.
+// If hashes is not nil, every extracted file is verified against it.
+// If shared is set, the files are placed into the objects, and linked into `dest`.
+func (s *Store) extractEntries(
+	compiled *pkg.Package, entries []pkg.Entry, dest string, hashes pkg.Hashes, shared bool,
+) error {
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+
+	extracted := make(map[string]bool)
+	for _, e := range entries {
+		destPath := path.Join(dest, e.Path)
+
+		if e.IsDir() {
+			if err := os.MkdirAll(destPath, os.ModePerm); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(path.Dir(destPath), os.ModePerm); err != nil {
+			return err
+		}
+
+		if err := s.extractEntry(compiled, e, destPath, hashes, shared); err != nil {
+			return err
+		}
+		extracted[e.ArchivePath()] = true
+	}
+
+	// Check that nothing is missing.
+	for archivePath := range hashes {
+		if !extracted[archivePath] {
+			return fmt.Errorf("%w: `%s`", pkg.ErrMissingFile, archivePath)
+		}
+	}
+
 	return nil
 }
+
+// extractEntry writes a single package entry to `destPath`.
+// If hashes is not nil, the written contents are verified against it.
+// If shared is set, the entry is written to an object, which is linked to `destPath`.
+func (s *Store) extractEntry(compiled *pkg.Package, e pkg.Entry, destPath string, hashes pkg.Hashes, shared bool) error {
+	var fileHash pkg.FileHash
+	if hashes != nil {
+		var ok bool
+		if fileHash, ok = hashes[e.ArchivePath()]; !ok {
+			return fmt.Errorf("%w: `%s`", pkg.ErrUnhashedFile, e.ArchivePath())
+		}
+	}
+
+	src, err := compiled.Open(e)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// The hash list records the mode as well, so it takes precedence over the archive one.
+	mode := e.Mode.Perm()
+	if hashes != nil {
+		mode = expectedMode(fileHash)
+	} else if mode == 0 {
+		mode = 0644
+	}
+
+	// Shared entries are written next to the objects, so that they could be renamed into one.
+	writePath := destPath
+	if shared {
+		if err := os.MkdirAll(s.objectsPath(), os.ModePerm); err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(s.objectsPath(), ".extract-*")
+		if err != nil {
+			return err
+		}
+		tmp.Close()
+		writePath = tmp.Name()
+		defer os.Remove(writePath)
+	}
+
+	dst, err := os.OpenFile(writePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	digest := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, digest), src)
+	if err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// Verify the data, as it was read from the package.
+	if hashes != nil {
+		if err := fileHash.Check(e.ArchivePath(), digest.Sum(nil), written); err != nil {
+			return err
+		}
+	}
+
+	// Apply the mode explicitly, since OpenFile is subject to umask.
+	if err := os.Chmod(writePath, mode); err != nil {
+		return err
+	}
+
+	if shared {
+		return s.placeObject(writePath, destPath, pkg.FileHash{SHA256: hex.EncodeToString(digest.Sum(nil)), Size: written, Mode: mode})
+	}
+	return nil
+}
+
+// readDirNames returns the names of all the directories inside the provided one.
+// A missing directory is treated as an empty one.
+func readDirNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// removeIfEmpty removes the directory if it has no entries left.
+func removeIfEmpty(dir string) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+		os.Remove(dir)
+	}
+}
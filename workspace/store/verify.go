@@ -0,0 +1,215 @@
+package store
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/utils/files"
+)
+
+// Kinds of the problems found by Verify.
+const (
+	ProblemMissing  = "missing"
+	ProblemModified = "modified"
+	ProblemExtra    = "extra"
+	ProblemMode     = "mode"
+)
+
+// Problem is a difference between the installed package files and the recorded hashes.
+type Problem struct {
+	Kind string
+	// Path is relative to the package data directory.
+	Path    string
+	Details string
+
+	archivePath string
+}
+
+// Verify compares the installed data files of the package against the hash list recorded at install time.
+// Packages installed without a hash list can't be verified, and pkg.ErrNoHashList is returned for them.
+func (s *Store) Verify(p Package) ([]Problem, error) {
+	hashes, err := p.hashes()
+	if err != nil {
+		return nil, err
+	}
+	if hashes == nil {
+		return nil, pkg.ErrNoHashList
+	}
+
+	// Map the installed file paths back onto the archive paths.
+	expected := make(map[string]string)
+	for archivePath := range hashes {
+		expected[p.dataFilePath(archivePath)] = archivePath
+	}
+
+	var problems []Problem
+	seen := make(map[string]bool)
+	err = filepath.WalkDir(p.DataPath, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		filePath := filepath.ToSlash(walkPath)
+		relativePath, err := filepath.Rel(p.DataPath, walkPath)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		if d.IsDir() {
+			return nil
+		}
+		archivePath, ok := expected[filePath]
+		if !ok {
+			problems = append(problems, Problem{Kind: ProblemExtra, Path: relativePath})
+			return nil
+		}
+		seen[filePath] = true
+
+		problem, err := verifyFile(filePath, hashes[archivePath])
+		if err != nil {
+			return err
+		}
+		if problem != nil {
+			problem.Path = relativePath
+			problem.archivePath = archivePath
+			problems = append(problems, *problem)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for filePath, archivePath := range expected {
+		if !seen[filePath] {
+			relativePath, _ := filepath.Rel(p.DataPath, filePath)
+			problems = append(problems, Problem{
+				Kind: ProblemMissing, Path: filepath.ToSlash(relativePath), archivePath: archivePath,
+			})
+		}
+	}
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems, nil
+}
+
+// verifyFile checks a single installed file against it's recorded hash.
+func verifyFile(filePath string, fileHash pkg.FileHash) (*Problem, error) {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return &Problem{Kind: ProblemModified, Details: fmt.Sprintf("not a regular file: %s", info.Mode().Type())}, nil
+	}
+
+	if info.Size() != fileHash.Size {
+		return &Problem{Kind: ProblemModified, Details: fmt.Sprintf("size %d, expected %d", info.Size(), fileHash.Size)}, nil
+	}
+	digest, err := files.HashFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(digest) != fileHash.SHA256 {
+		return &Problem{Kind: ProblemModified, Details: "contents differ"}, nil
+	}
+
+	if mode := expectedMode(fileHash); info.Mode().Perm() != mode {
+		return &Problem{Kind: ProblemMode, Details: fmt.Sprintf("%03o, expected %03o", info.Mode().Perm(), mode)}, nil
+	}
+
+	return nil, nil
+}
+
+// expectedMode returns the permissions the file is extracted with, according to the hash list.
+// Both the extraction and Verify use it, so that they can't disagree.
+func expectedMode(fileHash pkg.FileHash) fs.FileMode {
+	if fileHash.Mode.Perm() == 0 {
+		return 0644
+	}
+	return fileHash.Mode.Perm()
+}
+
+// Repair fixes the problems of the installed package, found by Verify, restoring the files from
+// it's original package file. Extra files are removed.
+func (s *Store) Repair(p Package, problems []Problem, r io.ReaderAt, size int64) error {
+	compiled, err := pkg.OpenPackage(r, size)
+	if err != nil {
+		return err
+	}
+	archived := Package{Manifest: compiled.Manifest(), CommonInfo: compiled.CommonInfo()}
+	if archived.Name() != p.Name() || !archived.CommonInfo.PkgVersion.EQ(p.CommonInfo.PkgVersion) {
+		return fmt.Errorf("%w: the package file contains %s %s, while %s %s is installed", ErrRepairMismatch,
+			archived.Name(), archived.CommonInfo.PkgVersion, p.Name(), p.CommonInfo.PkgVersion)
+	}
+
+	hashes, err := p.hashes()
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]pkg.Entry)
+	for _, e := range compiled.DataEntries() {
+		entries[e.ArchivePath()] = e
+	}
+
+	for _, problem := range problems {
+		filePath := path.Join(p.DataPath, problem.Path)
+
+		if problem.Kind == ProblemExtra {
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		e, ok := entries[problem.archivePath]
+		if !ok {
+			return fmt.Errorf("%w: `%s` isn't in the package file", ErrRepairMismatch, problem.archivePath)
+		}
+
+		if err := s.dropBrokenObject(hashes[problem.archivePath]); err != nil {
+			return err
+		}
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.MkdirAll(path.Dir(filePath), os.ModePerm); err != nil {
+			return err
+		}
+
+		// The contents are verified against the installed hash list, so a different package file can't slip in.
+		if err := s.extractEntry(compiled, e, filePath, hashes, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropBrokenObject removes the object of the file, if it doesn't match the recorded hash anymore.
+// Installed files are linked to their objects, so changing a file changes the object as well.
+func (s *Store) dropBrokenObject(fileHash pkg.FileHash) error {
+	objectPath := s.objectPath(fileHash.SHA256, expectedMode(fileHash))
+	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	problem, err := verifyFile(objectPath, fileHash)
+	if err != nil {
+		return err
+	}
+	if problem != nil {
+		return os.Remove(objectPath)
+	}
+	return nil
+}
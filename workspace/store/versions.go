@@ -0,0 +1,125 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/zhk-kk/raftpm/utils/files"
+)
+
+var (
+	ErrPackagePinned   = errors.New("package is pinned")
+	ErrNoActiveVersion = errors.New("package has no active version")
+)
+
+// Several versions of a binary package could be installed side by side, but only the active one
+// is linked into the `links` directory. The active version is recorded in `metadata/apps/<name>/active`.
+
+func (s Store) activeVersionPath(name string) string {
+	return path.Join(s.metadataPath(), "apps", name, "active")
+}
+
+// ActiveVersion returns the active version of the binary package.
+// The second value is false if the package has no active version.
+func (s *Store) ActiveVersion(name string) (string, bool, error) {
+	raw, err := os.ReadFile(s.activeVersionPath(name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(raw)), true, nil
+}
+
+// SetActiveVersion records the active version of the binary package.
+func (s *Store) SetActiveVersion(name string, version string) error {
+	return files.WriteFileAtomic(s.activeVersionPath(name), []byte(version+"\n"), 0644)
+}
+
+// clearActiveVersion forgets the active version of the binary package.
+func (s *Store) clearActiveVersion(name string) error {
+	if err := os.Remove(s.activeVersionPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Latest returns the highest installed version of the package.
+// The second value is false if no version is installed.
+func (s *Store) Latest(name string) (Package, bool, error) {
+	packages, err := s.Lookup(name)
+	if err != nil || len(packages) == 0 {
+		return Package{}, false, err
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].CommonInfo.PkgVersion.GT(packages[j].CommonInfo.PkgVersion)
+	})
+	return packages[0], true, nil
+}
+
+// Pinned returns the names of the pinned packages, which mustn't be upgraded.
+func (s *Store) Pinned() []string { return s.config.StringList("pinned") }
+
+// IsPinned reports whether the package is pinned.
+func (s *Store) IsPinned(name string) bool {
+	for _, pinned := range s.Pinned() {
+		if pinned == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckNotPinned fails, if the package is pinned, and a different version of it is active.
+func (s *Store) CheckNotPinned(name string, version semver.Version) error {
+	if !s.IsPinned(name) {
+		return nil
+	}
+
+	active, ok, err := s.ActiveVersion(name)
+	if err != nil {
+		return err
+	}
+	if ok && active != version.String() {
+		return fmt.Errorf("%w: `%s` is pinned to %s", ErrPackagePinned, name, active)
+	}
+	return nil
+}
+
+// Pin stops the package from being upgraded. It holds the active version,
+// so packages without one, e.g. integration scripts packages, can't be pinned.
+func (s *Store) Pin(name string) error {
+	if s.IsPinned(name) {
+		return nil
+	}
+
+	if _, ok, err := s.ActiveVersion(name); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("%w: `%s`", ErrNoActiveVersion, name)
+	}
+
+	pinned := append(append([]string{}, s.Pinned()...), name)
+	sort.Strings(pinned)
+	s.config.SetStringList("pinned", pinned)
+	return s.config.Flush()
+}
+
+// Unpin allows the package to be upgraded again.
+func (s *Store) Unpin(name string) error {
+	pinned := []string{}
+	for _, other := range s.Pinned() {
+		if other != name {
+			pinned = append(pinned, other)
+		}
+	}
+
+	s.config.SetStringList("pinned", pinned)
+	return s.config.Flush()
+}
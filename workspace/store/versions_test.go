@@ -0,0 +1,37 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPin(t *testing.T) {
+	tests := []struct {
+		name    string
+		active  string
+		wantErr error
+	}{
+		{name: "active version", active: "1.0.0"},
+		{name: "no active version", wantErr: ErrNoActiveVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			addPackage(t, s, "app", "1.0.0", nil)
+			if tt.active != "" {
+				if err := s.SetActiveVersion("app", tt.active); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := s.Pin("app")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if pinned := s.IsPinned("app"); pinned != (tt.wantErr == nil) {
+				t.Errorf("app is pinned: %t", pinned)
+			}
+		})
+	}
+}
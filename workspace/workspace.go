@@ -2,25 +2,49 @@ package workspace
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path"
 
+	"github.com/zhk-kk/raftpm/pkg"
+	"github.com/zhk-kk/raftpm/pkg/manifest"
+	"github.com/zhk-kk/raftpm/utils/files"
 	"github.com/zhk-kk/raftpm/workspace/cache"
 	"github.com/zhk-kk/raftpm/workspace/common"
 	"github.com/zhk-kk/raftpm/workspace/config"
+	"github.com/zhk-kk/raftpm/workspace/keyring"
 	"github.com/zhk-kk/raftpm/workspace/links"
 	"github.com/zhk-kk/raftpm/workspace/store"
 )
 
+// Signature policies, deciding what to do with unsigned packages,
+// or packages signed with untrusted keys.
+const (
+	SignaturePolicyReject        = "reject"
+	SignaturePolicyWarn          = "warn"
+	SignaturePolicyAllowUnsigned = "allow-unsigned"
+)
+
+// Config sections, naming the config files of the workspace and it's core elements.
+const (
+	ConfigSectionWorkspace = "workspace"
+	ConfigSectionStore     = "store"
+	ConfigSectionLinks     = "links"
+	ConfigSectionCache     = "cache"
+)
+
 type Workspace struct {
 	path string
 
 	config *config.Config
+	// configs maps the config sections onto the config files.
+	configs map[string]*config.Config
 
-	cache *cache.Cache
-	links *links.Links
-	store *store.Store
+	cache   *cache.Cache
+	links   *links.Links
+	store   *store.Store
+	keyring *keyring.Keyring
 
 	portable bool
 }
@@ -33,6 +57,8 @@ func (w Workspace) workConfigPath() string  { return path.Join(w.configDir(), "w
 func (w Workspace) storeConfigPath() string { return path.Join(w.configDir(), "store") }
 func (w Workspace) linksConfigPath() string { return path.Join(w.configDir(), "links") }
 func (w Workspace) cacheConfigPath() string { return path.Join(w.configDir(), "cache") }
+func (w Workspace) keyringDir() string      { return path.Join(w.configDir(), "trusted-keys") }
+func (w Workspace) backupsDir() string      { return path.Join(w.path, "backups") }
 
 func (w Workspace) portableFlagFilePath() string { return path.Join(w.path, ".portable") }
 
@@ -45,10 +71,70 @@ func NewWorkspace(workspacePath string) *Workspace {
 	// Create the config.
 	w.config = config.NewConfig(w.workConfigPath())
 	w.config.AddBool("isPortable", true, false)
+	// Workspaces created before the layout was versioned have no layout version.
+	w.config.AddInt("layoutVersion", false, 0)
+	w.config.MakeReadOnly("layoutVersion")
+	w.config.AddStringChoice("signaturePolicy", false, SignaturePolicyWarn, []string{
+		SignaturePolicyReject, SignaturePolicyWarn, SignaturePolicyAllowUnsigned,
+	})
+
+	// Create all the core elements.
+	w.configs = map[string]*config.Config{
+		ConfigSectionWorkspace: w.config,
+		ConfigSectionStore:     config.NewConfig(w.storeConfigPath()),
+		ConfigSectionLinks:     config.NewConfig(w.linksConfigPath()),
+		ConfigSectionCache:     config.NewConfig(w.cacheConfigPath()),
+	}
+	w.cache = cache.NewCache(w.cacheDir(), w.configs[ConfigSectionCache])
+	w.links = links.NewLinks(w.linksDir(), w.configs[ConfigSectionLinks])
+	w.store = store.NewStore(w.storeDir(), w.configs[ConfigSectionStore])
+	w.keyring = keyring.NewKeyring(w.keyringDir())
 
 	return &w
 }
 
+// elements returns all the core workspace elements, in the order of initialization.
+func (w *Workspace) elements() []common.WorkspaceElement {
+	return []common.WorkspaceElement{
+		w.cache,
+		w.links,
+		w.store,
+		w.keyring,
+	}
+}
+
+// ConfigEntries and DataEntries return the names of everything the workspace keeps in it's directory.
+// The config entries are needed to load the workspace, the data ones hold the rest.
+// Other files in the directory, like the raftpm executable, aren't part of the workspace.
+func (w *Workspace) ConfigEntries() []string {
+	return []string{path.Base(w.configDir()), path.Base(w.portableFlagFilePath())}
+}
+func (w *Workspace) DataEntries() []string {
+	return []string{path.Base(w.storeDir()), path.Base(w.linksDir()), path.Base(w.cacheDir()), path.Base(w.backupsDir())}
+}
+
+// Dirs returns all the directories created by Init, parents first.
+func (w *Workspace) Dirs() []string {
+	dirs := []string{w.path, w.configDir()}
+	for _, e := range w.elements() {
+		dirs = append(dirs, e.Dirs()...)
+	}
+	return dirs
+}
+
+// Exists reports whether the workspace was initialized, without loading it.
+// Workspaces created before the workspace config are recognized by their store.
+func (w *Workspace) Exists() (bool, error) {
+	for _, p := range []string{w.config.Path(), w.storeDir()} {
+		if _, err := os.Stat(p); err == nil {
+			return true, nil
+		} else if !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
 // Init initializes workspace, creating the workspace structure.
 // Initializes all the contents of the workspace.
 // Does nothing if the workspace is already initialized, apart from creating missing structures.
@@ -58,19 +144,37 @@ func (w *Workspace) Init() error {
 		return fmt.Errorf("%s: %w", "unable to initialize the workspace directory", err)
 	}
 
-	// Create all the core elements.
-	w.cache = cache.NewCache(w.cacheDir(), config.NewConfig(w.cacheConfigPath()))
-	w.links = links.NewLinks(w.linksDir(), config.NewConfig(w.linksConfigPath()))
-	w.store = store.NewStore(w.storeDir(), config.NewConfig(w.storeConfigPath()))
+	// New workspaces have the current layout, while existing ones without a config predate versioning.
+	// Such workspaces were only marked portable by the flag file.
+	if _, err := os.Stat(w.config.Path()); os.IsNotExist(err) {
+		if _, err := os.Stat(w.storeDir()); os.IsNotExist(err) {
+			w.config.SetInt("layoutVersion", LayoutVersion())
+		} else if err != nil {
+			return err
+		}
 
-	// Initialize all the core workspace elements.
-	workElemsInitOrder := []common.WorkspaceElement{
-		w.cache,
-		w.links,
-		w.store,
+		if _, err := os.Stat(w.portableFlagFilePath()); err == nil {
+			w.config.SetBool("isPortable", true)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	// Create the config files, which are missing.
+	for section, c := range w.configs {
+		if _, err := os.Stat(c.Path()); os.IsNotExist(err) {
+			if err := c.Flush(); err != nil {
+				return fmt.Errorf("unable to create the %s config: %w", section, err)
+			}
+		} else if err != nil {
+			return err
+		}
 	}
 
-	for _, e := range workElemsInitOrder {
+	// Initialize all the core workspace elements.
+	for _, e := range w.elements() {
 		if err := e.Init(); err != nil {
 			return err
 		}
@@ -81,25 +185,24 @@ func (w *Workspace) Init() error {
 
 // Load loads the workspace, along with all the core elements.
 func (w *Workspace) Load() error {
-	// Read the config.
-
-	// Check if portable.
-	// if _, err := os.Stat(w.portableFlagFilePath()); err == nil {
-	// 	w.portable = true
-	// } else if os.IsNotExist(err) {
-	// 	w.portable = false
-	// } else {
-	// 	return err
-	// }
+	// Migrate the workspace to the current layout.
+	if err := w.config.Read(); err != nil {
+		return fmt.Errorf("unable to read the workspace config: %w", err)
+	}
+	if err := w.migrate(); err != nil {
+		return err
+	}
 
-	// Load all the core elements.
-	workElemsLoadOrder := []common.WorkspaceElement{
-		w.cache,
-		w.links,
-		w.store,
+	// Read the configs.
+	for section, c := range w.configs {
+		if err := c.Read(); err != nil {
+			return fmt.Errorf("unable to read the %s config: %w", section, err)
+		}
 	}
+	w.portable = w.config.Bool("isPortable")
 
-	for _, e := range workElemsLoadOrder {
+	// Load all the core elements.
+	for _, e := range w.elements() {
 		if err := e.Load(); err != nil {
 			return err
 		}
@@ -110,6 +213,197 @@ func (w *Workspace) Load() error {
 
 func (w *Workspace) Portable() bool { return w.portable }
 
+func (w *Workspace) Path() string              { return w.path }
+func (w *Workspace) Store() *store.Store       { return w.store }
+func (w *Workspace) Links() *links.Links       { return w.links }
+func (w *Workspace) Cache() *cache.Cache       { return w.cache }
+func (w *Workspace) Keyring() *keyring.Keyring { return w.keyring }
+
+// Configs returns the config files of the workspace and it's core elements, keyed by their sections.
+func (w *Workspace) Configs() map[string]*config.Config { return w.configs }
+
+// SignaturePolicy returns the policy for unsigned and untrusted packages.
+func (w *Workspace) SignaturePolicy() string { return w.config.String("signaturePolicy") }
+
+// Install installs the compiled package into the workspace store.
+// The installed version of a binary package becomes the active one.
+// Installing another version of a pinned package fails.
+// The reason is one of store.InstallReasonXxx, it's recorded in the store index.
+func (w *Workspace) Install(r io.ReaderAt, size int64, reason string) (store.Package, error) {
+	p, err := w.store.Install(r, size, reason)
+	if err != nil {
+		return p, err
+	}
+
+	if err := w.cacheArchive(p, io.NewSectionReader(r, 0, size)); err != nil {
+		w.store.Remove(p)
+		return p, fmt.Errorf("couldn't cache the package file: %w", err)
+	}
+
+	if err := w.Activate(p); err != nil {
+		w.store.Remove(p)
+		return p, err
+	}
+	p.Active = true
+
+	return p, nil
+}
+
+// cacheArchive keeps the original package file in the cache, unless it's disabled.
+func (w *Workspace) cacheArchive(p store.Package, r io.Reader) error {
+	if !w.cache.KeepArchives() {
+		return nil
+	}
+
+	switch pkgManifest := p.Manifest.(type) {
+	case manifest.BinaryPkg:
+		return w.cache.PutPackageArchive(pkgManifest.Name, p.CommonInfo.PkgVersion.String(), r)
+	case manifest.IntegrationScriptsPkg:
+		return w.cache.PutTargetArchive(pkgManifest.TargetName, p.CommonInfo.PkgVersion.String(), r)
+	}
+	return nil
+}
+
+// Archive returns the path of the cached original package file of the installed package.
+// If it isn't cached, false is returned.
+func (w *Workspace) Archive(p store.Package) (string, bool, error) {
+	switch pkgManifest := p.Manifest.(type) {
+	case manifest.BinaryPkg:
+		return w.cache.PackageArchive(pkgManifest.Name, p.CommonInfo.PkgVersion.String())
+	case manifest.IntegrationScriptsPkg:
+		return w.cache.TargetArchive(pkgManifest.TargetName, p.CommonInfo.PkgVersion.String())
+	}
+	return "", false, nil
+}
+
+// Activate makes the installed version of the binary package the active one,
+// repointing the link entries of the package to it's shell executables.
+// If the links couldn't be created, the links of the previously active version are restored.
+func (w *Workspace) Activate(p store.Package) error {
+	binPkg, ok := p.Manifest.(manifest.BinaryPkg)
+	if !ok {
+		return nil
+	}
+
+	packages, err := w.store.Lookup(binPkg.Name)
+	if err != nil {
+		return err
+	}
+	var previous *store.Package
+	for i := range packages {
+		if packages[i].Active {
+			previous = &packages[i]
+		}
+	}
+
+	if previous != nil {
+		if err := w.removeLinks(*previous); err != nil {
+			return fmt.Errorf("couldn't remove the links: %w", err)
+		}
+	}
+
+	if err := w.createLinks(p); err != nil {
+		if previous != nil {
+			w.createLinks(*previous)
+		}
+		return fmt.Errorf("couldn't create the links: %w", err)
+	}
+
+	if err := w.store.SetActiveVersion(binPkg.Name, p.CommonInfo.PkgVersion.String()); err != nil {
+		w.removeLinks(p)
+		if previous != nil {
+			w.createLinks(*previous)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// createLinks creates the link entries for all the shell executables of the package.
+// If any of them couldn't be created, the already created ones are removed.
+func (w *Workspace) createLinks(p store.Package) error {
+	binPkg, ok := p.Manifest.(manifest.BinaryPkg)
+	if !ok {
+		return nil
+	}
+
+	var created []string
+	for name, bin := range binPkg.BinShellExe {
+		binPath, ok := binPkg.BinRegistry[bin]
+		if !ok {
+			w.links.Remove(created...)
+			return fmt.Errorf("%w: `%s`", pkg.ErrUnregisteredBinaryReferenced, bin)
+		}
+
+		if err := w.links.Create(name, path.Join(p.DataPath, binPath.Path)); err != nil {
+			w.links.Remove(created...)
+			return err
+		}
+		created = append(created, name)
+	}
+
+	return nil
+}
+
+// removeLinks removes the link entries of all the shell executables of the package.
+func (w *Workspace) removeLinks(p store.Package) error {
+	binPkg, ok := p.Manifest.(manifest.BinaryPkg)
+	if !ok {
+		return nil
+	}
+
+	names := make([]string, 0, len(binPkg.BinShellExe))
+	for name := range binPkg.BinShellExe {
+		names = append(names, name)
+	}
+	return w.links.Remove(names...)
+}
+
+// Uninstall removes the installed package from the workspace, along with
+// all the link entries generated from it, and all of it's cached data.
+// If the active version of a binary package is removed, the highest remaining version becomes active.
+func (w *Workspace) Uninstall(p store.Package) error {
+	if p.Active {
+		if err := w.removeLinks(p); err != nil {
+			return fmt.Errorf("couldn't remove the links: %w", err)
+		}
+	}
+
+	if err := w.store.Remove(p); err != nil {
+		return fmt.Errorf("couldn't remove the package from the store: %w", err)
+	}
+
+	switch pkgManifest := p.Manifest.(type) {
+	case manifest.BinaryPkg:
+		latest, ok, err := w.store.Latest(pkgManifest.Name)
+		if err != nil {
+			return err
+		}
+
+		if err := w.cache.DropPackageArchive(pkgManifest.Name, p.CommonInfo.PkgVersion.String()); err != nil {
+			return fmt.Errorf("couldn't drop the cached package file: %w", err)
+		}
+
+		// The rest of the cached data is shared by all the versions of the package.
+		if !ok {
+			if err := w.cache.DropPackage(pkgManifest.Name); err != nil {
+				return fmt.Errorf("couldn't drop the cached data: %w", err)
+			}
+		} else if p.Active {
+			if err := w.Activate(latest); err != nil {
+				return fmt.Errorf("couldn't activate %s %s: %w", pkgManifest.Name, latest.CommonInfo.PkgVersion, err)
+			}
+		}
+	case manifest.IntegrationScriptsPkg:
+		if err := w.cache.DropTarget(pkgManifest.TargetName); err != nil {
+			return fmt.Errorf("couldn't drop the cached data: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (w *Workspace) Editor() *workspaceEditor {
 	return &workspaceEditor{
 		w: w,
@@ -125,8 +419,13 @@ func (we *workspaceEditor) Portable(p bool) *workspaceEditor { we.portable = &p;
 
 func (we *workspaceEditor) ApplyChanges() error {
 	if we.portable != nil {
+		we.w.config.SetBool("isPortable", *we.portable)
+		we.w.portable = *we.portable
+
+		// The flag file lets raftpm know it's portable before any workspace is loaded.
 		if *we.portable {
-			if _, err := os.Create(we.w.portableFlagFilePath()); err != nil {
+			// The file is replaced instead of truncated, since it may be linked to another workspace.
+			if err := files.WriteFileAtomic(we.w.portableFlagFilePath(), nil, 0644); err != nil {
 				return err
 			}
 		} else {
@@ -136,5 +435,5 @@ func (we *workspaceEditor) ApplyChanges() error {
 		}
 	}
 
-	return nil
+	return we.w.config.Flush()
 }